@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/mikeunge/sshman/internal/agent"
+	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/internal/profiles"
+	"github.com/mikeunge/sshman/pkg/config"
+	"github.com/mikeunge/sshman/pkg/helpers"
+
+	"github.com/pterm/pterm"
+)
+
+// runSubcommand dispatches positional commands like `sshman db migrate`.
+func runSubcommand(sub []string, args map[string]interface{}, found map[string]*bool, store database.Store, profileService *profiles.ProfileService) error {
+	switch sub[0] {
+	case "db":
+		db, ok := store.(*database.DB)
+		if !ok {
+			return fmt.Errorf("database commands are only available for the %s storage backend", database.BackendSQLite)
+		}
+		return runDatabaseCommand(sub[1:], found, db)
+	case "template":
+		return runTemplateCommand(sub[1:], found, profileService)
+	case "vault":
+		return runVaultCommand(sub[1:], profileService)
+	case "rekey":
+		return profileService.Rekey()
+	case "history":
+		return profileService.History(subcommandArg(sub, args, found))
+	case "revisions":
+		return profileService.Revisions(subcommandArg(sub, args, found))
+	case "rollback":
+		return profileService.Rollback(subcommandArg(sub, args, found), *args["to"].(*string))
+	case "hostkey":
+		return runHostKeyCommand(sub[1:], args, found, profileService)
+	default:
+		return fmt.Errorf("unknown command '%s', see --help for available commands", sub[0])
+	}
+}
+
+// subcommandArg returns the profile passed to a command, either positional or via --alias/--id.
+func subcommandArg(sub []string, args map[string]interface{}, found map[string]*bool) string {
+	if len(sub) > 1 {
+		return sub[1]
+	}
+	return getAdditionalArg(args, found)
+}
+
+// runAgentCommand runs the agent in the foreground until it gets interrupted, or locks the running agent.
+func runAgentCommand(sub []string, cfg config.Config) error {
+	switch sub[0] {
+	case "agent":
+		server := agent.NewServer(agent.SocketPath(), time.Duration(cfg.AgentTimeout)*time.Minute)
+		if err := server.Listen(); err != nil {
+			return fmt.Errorf("could not start the agent, %s", err.Error())
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			server.Close()
+		}()
+
+		pterm.Info.Printf("Agent listening on %s, keys are forgotten after %d minute(s) without use.\n", server.Path, cfg.AgentTimeout)
+		return server.Serve()
+	case "lock":
+		keys, err := agent.NewClient(agent.SocketPath()).Lock()
+		if err != nil {
+			return err
+		}
+		pterm.Success.Printf("Agent locked, %d key(s) forgotten.\n", keys)
+		return nil
+	default:
+		return fmt.Errorf("unknown command '%s', see --help for available commands", sub[0])
+	}
+}
+
+func runTemplateCommand(sub []string, found map[string]*bool, profileService *profiles.ProfileService) error {
+	if len(sub) == 0 {
+		return fmt.Errorf("missing template command, see --help for available commands")
+	}
+
+	switch sub[0] {
+	case "new":
+		return profileService.NewTemplate(*found["no-encryption"])
+	case "list":
+		return profileService.TemplatesList()
+	default:
+		return fmt.Errorf("unknown template command '%s'", sub[0])
+	}
+}
+
+func runVaultCommand(sub []string, profileService *profiles.ProfileService) error {
+	if len(sub) == 0 {
+		return fmt.Errorf("missing vault command, see --help for available commands")
+	}
+
+	switch sub[0] {
+	case "init":
+		return profileService.InitVault()
+	case "status":
+		return profileService.VaultStatus()
+	default:
+		return fmt.Errorf("unknown vault command '%s'", sub[0])
+	}
+}
+
+func runHostKeyCommand(sub []string, args map[string]interface{}, found map[string]*bool, profileService *profiles.ProfileService) error {
+	if len(sub) == 0 {
+		return fmt.Errorf("missing hostkey command, see --help for available commands")
+	}
+
+	switch sub[0] {
+	case "reset":
+		return profileService.ResetHostKey(subcommandArg(sub, args, found))
+	default:
+		return fmt.Errorf("unknown hostkey command '%s'", sub[0])
+	}
+}
+
+func runDatabaseCommand(sub []string, found map[string]*bool, db *database.DB) error {
+	if len(sub) == 0 {
+		return fmt.Errorf("missing database command, see --help for available commands")
+	}
+
+	switch sub[0] {
+	case "migrate":
+		if *found["status"] {
+			return printMigrationStatus(db)
+		}
+		return migrateDatabase(db)
+	case "backup":
+		path := filepath.Join(db.BackupDir(), fmt.Sprintf("sshman-%s.db", time.Now().Format("20060102-150405")))
+		if len(sub) > 1 {
+			path = helpers.SanitizePath(sub[1])
+		}
+		if err := db.Backup(path); err != nil {
+			return fmt.Errorf("could not create backup, %s", err.Error())
+		}
+		pterm.Success.Printf("Backup created: %s\n", path)
+		return nil
+	case "restore":
+		if len(sub) < 2 {
+			return fmt.Errorf("please provide the backup to restore")
+		}
+		return restoreDatabase(db, helpers.SanitizePath(sub[1]))
+	default:
+		return fmt.Errorf("unknown database command '%s'", sub[0])
+	}
+}
+
+// migrateDatabase applies the pending migrations, the database was opened without applying them.
+func migrateDatabase(db *database.DB) error {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if latest := database.LatestSchemaVersion(); current == latest {
+		pterm.Info.Printf("Database schema is up to date (version %d).\n", current)
+		return nil
+	}
+
+	if err = db.Migrate(); err != nil {
+		return err
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	pterm.Success.Printf("Applied %d migration(s), the database schema is now at version %d.\n", version-current, version)
+	return nil
+}
+
+func restoreDatabase(db *database.DB, path string) error {
+	if r, _ := pterm.DefaultInteractiveConfirm.WithDefaultText(fmt.Sprintf("Replace all profiles with the content of %s?", path)).Show(); !r {
+		fmt.Println()
+		pterm.Info.Println("Restore aborted, exiting.")
+		return nil
+	}
+
+	// The restore replaces everything, so keep the current state around
+	backup, err := db.AutoBackup("restore")
+	if err != nil {
+		return fmt.Errorf("could not back up the current database, %s", err.Error())
+	}
+
+	version, err := db.Restore(path)
+	if err != nil {
+		return fmt.Errorf("could not restore %s, %s", path, err.Error())
+	}
+
+	fmt.Println()
+	pterm.Success.Printf("Restored %s (schema version %d).\n", path, version)
+	if len(backup) > 0 {
+		pterm.Info.Printf("The previous database was saved to %s\n", backup)
+	}
+	return nil
+}
+
+func printMigrationStatus(db *database.DB) error {
+	var data [][]string
+	var dFormat = "02.01.2006 15:04"
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	data = append(data, []string{"Version", "Description", "Status", "Applied At"}) // define the table header
+	for _, m := range status {
+		state := pterm.Yellow("pending")
+		appliedAt := "-"
+		if m.Applied {
+			state = pterm.Green("applied")
+			appliedAt = m.AppliedAt.Format(dFormat)
+		}
+		data = append(data, []string{fmt.Sprintf("%d", m.Version), m.Description, state, appliedAt})
+	}
+	pterm.DefaultTable.
+		WithHasHeader().
+		WithData(data).
+		Render()
+	return nil
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/mikeunge/sshman/internal/agent"
 	"github.com/mikeunge/sshman/internal/cli"
 	"github.com/mikeunge/sshman/internal/database"
 	"github.com/mikeunge/sshman/internal/profiles"
+	"github.com/mikeunge/sshman/internal/secrets"
 	"github.com/mikeunge/sshman/pkg/config"
 	"github.com/mikeunge/sshman/pkg/logger"
 
@@ -26,6 +29,22 @@ func main() {
 		Author:      "@mikeunge",
 		Version:     "1.4.0",
 		Github:      "https://github.com/mikeunge/sshman",
+		Commands: []cli.Command{
+			{Name: "db migrate [--status]", Help: "Apply pending schema migrations or show their status."},
+			{Name: "db backup [path]", Help: "Create a backup of the database while it's in use."},
+			{Name: "db restore <file>", Help: "Replace the database with a backup."},
+			{Name: "template new [--no-encrypt]", Help: "Create a template profiles can inherit from."},
+			{Name: "template list", Help: "Show all templates and how many profiles use them."},
+			{Name: "vault init", Help: "Encrypt all profiles with a single master password."},
+			{Name: "vault status", Help: "Show whether vault mode is enabled and its key derivation settings."},
+			{Name: "rekey", Help: "Re-encrypt all encrypted profiles with a new key or master password."},
+			{Name: "agent", Help: "Run the agent that remembers encryption keys until it is locked or idle."},
+			{Name: "lock", Help: "Make the agent forget all encryption keys."},
+			{Name: "history [alias]", Help: "Show the connection history of all or a single profile."},
+			{Name: "revisions <alias>", Help: "Show the previous versions of a profile."},
+			{Name: "rollback <alias> --to <rev>", Help: "Restore a profile to a previous revision."},
+			{Name: "hostkey reset <alias>", Help: "Forget the trusted host key of a profile."},
+		},
 	}
 
 	args, argsFound, err := app.New()
@@ -38,7 +57,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	db := &database.DB{Path: cfg.DatabasePath}
+	// The agent commands don't need the database
+	if sub := cli.Subcommand(); len(sub) > 0 && (sub[0] == "agent" || sub[0] == "lock") {
+		if err = runAgentCommand(sub, cfg); err != nil {
+			pterm.Error.Printf("%s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	db, err := database.NewStore(cfg.Storage, cfg.DatabasePath)
+	if err != nil {
+		pterm.Error.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+	if sqlite, ok := db.(*database.DB); ok {
+		sqlite.BackupRotation = cfg.BackupRotation
+		// Every other command migrates on connect, db migrate has to see (and apply) the pending ones itself
+		if sub := cli.Subcommand(); len(sub) > 1 && sub[0] == "db" && sub[1] == "migrate" {
+			sqlite.SkipMigrations = true
+		}
+	}
 	if err = db.Connect(); err != nil {
 		pterm.Error.Printf("%s\n", err.Error())
 		os.Exit(1)
@@ -57,13 +96,30 @@ func main() {
 	}()
 
 	profileService := profiles.ProfileService{
-		DB:                db,
+		Store:             db,
 		MaskInput:         cfg.MaskInput,
 		DecryptionRetries: cfg.DecryptionRetries,
 		Logger:            logger,
+		TagFilter:         *args["tag"].(*[]string),
+		TrashRetention:    time.Duration(cfg.TrashRetention) * 24 * time.Hour,
+		KDFIterations:     uint32(cfg.KDFIterations),
+		KDFMemory:         uint32(cfg.KDFMemory),
+		KDFThreads:        uint8(cfg.KDFThreads),
+		Agent:             agent.NewClient(agent.SocketPath()),
+		SecretProviders:   secrets.NewProviders(cfg.SecretProviders),
+		UseKnownHosts:     cfg.UseKnownHosts,
+	}
+
+	if sub := cli.Subcommand(); len(sub) > 0 {
+		if err = runSubcommand(sub, args, argsFound, db, &profileService); err != nil {
+			fmt.Println()
+			pterm.Error.Printf("%s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
-	nonValidCommands := []string{"no-encrypt", "id", "alias", "from", "to"}
+	nonValidCommands := []string{"no-encrypt", "id", "alias", "from", "to", "status", "tag", "bundle"}
 	command, _ := determineNextStep(args, argsFound, nonValidCommands)
 
 	switch command {
@@ -75,9 +131,20 @@ func main() {
 	case "delete":
 		additionalArg := getAdditionalArg(args, argsFound)
 		err = profileService.DeleteProfile(additionalArg)
+	case "trash":
+		err = profileService.TrashList()
+	case "restore":
+		additionalArg := getAdditionalArg(args, argsFound)
+		err = profileService.RestoreProfile(additionalArg)
+	case "purge":
+		err = profileService.PurgeTrash()
 	case "export":
 		additionalArg := getAdditionalArg(args, argsFound)
-		err = profileService.ExportProfile(additionalArg)
+		if *argsFound["bundle"] {
+			err = profileService.ExportBundle(additionalArg)
+		} else {
+			err = profileService.ExportProfile(additionalArg)
+		}
 	case "import":
 		additionalArg := args["import"].(*string)
 		err = profileService.ImportProfile(*additionalArg)
@@ -86,6 +153,17 @@ func main() {
 	case "update":
 		additionalArg := getAdditionalArg(args, argsFound)
 		err = profileService.UpdateProfile(additionalArg)
+	case "clone":
+		additionalArg := args["clone"].(*string)
+		err = profileService.CloneProfile(*additionalArg)
+	case "encrypt":
+		additionalArg := args["encrypt"].(*string)
+		err = profileService.EncryptProfile(*additionalArg)
+	case "encrypt-all":
+		err = profileService.EncryptAllProfiles()
+	case "decrypt-store":
+		additionalArg := args["decrypt-store"].(*string)
+		err = profileService.DecryptStoredProfile(*additionalArg)
 	case "scp":
 		fromArg := args["from"].(*string)
 		toArg := args["to"].(*string)
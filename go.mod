@@ -5,6 +5,7 @@ go 1.22.0
 require (
 	atomicgo.dev/keyboard v0.2.9
 	github.com/JoaoDanielRufino/go-input-autocomplete v1.0.4
+	github.com/bramvdbogaerde/go-scp v1.6.0
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/melbahja/goph v1.4.0
 	github.com/mikeunge/argparser v0.1.3-alpha
@@ -16,7 +17,6 @@ require (
 require (
 	atomicgo.dev/cursor v0.2.0 // indirect
 	atomicgo.dev/schedule v0.1.0 // indirect
-	github.com/bramvdbogaerde/go-scp v1.6.0 // indirect
 	github.com/containerd/console v1.0.3 // indirect
 	github.com/eiannone/keyboard v0.0.0-20200508000154-caf4b762e807 // indirect
 	github.com/gookit/color v1.5.4 // indirect
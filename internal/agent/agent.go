@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Time the agent keeps keys if nothing else is configured
+const DefaultTimeout = 15 * time.Minute
+
+// Operations understood by the agent
+const (
+	opGet  = "get"
+	opSet  = "set"
+	opLock = "lock"
+)
+
+type request struct {
+	Op   string `json:"op"`
+	Name string `json:"name,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+type response struct {
+	Key   string `json:"key,omitempty"`
+	Keys  int    `json:"keys"`
+	Error string `json:"error,omitempty"`
+}
+
+// SocketPath returns the socket of the agent, it lives in a directory only the user can access.
+func SocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "sshman", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("sshman-%d", os.Getuid()), "agent.sock")
+}
+
+// checkSocket makes sure the socket and its directory belong to the user and nobody else can access the directory.
+// Without $XDG_RUNTIME_DIR the directory lives in the shared temp directory, where another user could have created it first.
+func checkSocket(path string) error {
+	dir := filepath.Dir(path)
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err = checkOwner(info); err != nil {
+		return fmt.Errorf("%s %s", dir, err.Error())
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s has mode %04o instead of 0700", dir, info.Mode().Perm())
+	}
+
+	info, err = os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s is not a socket", path)
+	}
+	if err = checkOwner(info); err != nil {
+		return fmt.Errorf("%s %s", path, err.Error())
+	}
+	return nil
+}
+
+func checkOwner(info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("has no owner")
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("is owned by uid %d instead of %d", stat.Uid, os.Getuid())
+	}
+	return nil
+}
+
+// Server holds derived keys in memory until it gets locked or wasn't used for longer than the timeout.
+type Server struct {
+	Path    string
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	keys     map[string][]byte
+	timer    *time.Timer
+	listener net.Listener
+}
+
+func NewServer(path string, timeout time.Duration) *Server {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Server{Path: path, Timeout: timeout, keys: make(map[string][]byte)}
+}
+
+// Listen creates the socket, a socket left behind by an agent that didn't shut down cleanly is replaced.
+func (s *Server) Listen() error {
+	if NewClient(s.Path).Running() {
+		return fmt.Errorf("an agent is already running on %s", s.Path)
+	}
+
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dir); err != nil {
+		return err
+	} else if err = checkOwner(info); err != nil || !info.IsDir() {
+		return fmt.Errorf("refusing to listen in %s, it isn't a directory owned by you", dir)
+	}
+	if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.Path)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(s.Path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	s.listener = listener
+	return nil
+}
+
+// Serve answers requests until Close is called.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close wipes all keys and removes the socket.
+func (s *Server) Close() error {
+	s.Lock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Lock wipes all keys and returns how many there were.
+func (s *Server) Lock() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.keys)
+	for name, key := range s.keys {
+		clear(key)
+		delete(s.keys, name)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	return n
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var req request
+	var res response
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+
+	switch req.Op {
+	case opGet:
+		res.Key = s.get(req.Name)
+	case opSet:
+		s.set(req.Name, req.Key)
+	case opLock:
+		res.Keys = s.Lock()
+	default:
+		res.Error = fmt.Sprintf("unknown operation '%s'", req.Op)
+	}
+	json.NewEncoder(conn).Encode(res)
+}
+
+func (s *Server) get(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[name]
+	if !ok {
+		return ""
+	}
+	s.touch()
+	return string(key)
+}
+
+func (s *Server) set(name string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.keys[name])
+	s.keys[name] = []byte(key)
+	s.touch()
+}
+
+// touch restarts the idle timeout, the caller has to hold the lock.
+func (s *Server) touch() {
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.Timeout, func() { s.Lock() })
+		return
+	}
+	s.timer.Reset(s.Timeout)
+}
+
+// Client talks to a running agent, every call fails if there is none.
+type Client struct {
+	Path string
+}
+
+func NewClient(path string) *Client {
+	return &Client{Path: path}
+}
+
+// dial only connects to a socket that belongs to the user, keys are never sent to an agent of someone else.
+func (c *Client) dial() (net.Conn, error) {
+	if err := checkSocket(c.Path); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no agent is running on %s", c.Path)
+	} else if err != nil {
+		return nil, fmt.Errorf("refusing to use the agent on %s, %s", c.Path, err.Error())
+	}
+
+	conn, err := net.DialTimeout("unix", c.Path, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("no agent is running on %s", c.Path)
+	}
+	return conn, nil
+}
+
+func (c *Client) Running() bool {
+	conn, err := c.dial()
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Get returns the key stored under name, it is empty if the agent doesn't know it.
+func (c *Client) Get(name string) (string, error) {
+	res, err := c.call(request{Op: opGet, Name: name})
+	return res.Key, err
+}
+
+func (c *Client) Set(name string, key string) error {
+	_, err := c.call(request{Op: opSet, Name: name, Key: key})
+	return err
+}
+
+// Lock wipes all keys of the agent and returns how many there were.
+func (c *Client) Lock() (int, error) {
+	res, err := c.call(request{Op: opLock})
+	return res.Keys, err
+}
+
+func (c *Client) call(req request) (response, error) {
+	var res response
+
+	conn, err := c.dial()
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return res, err
+	}
+	if err = json.NewDecoder(conn).Decode(&res); err != nil {
+		return res, err
+	}
+	if len(res.Error) > 0 {
+		return res, fmt.Errorf("%s", res.Error)
+	}
+	return res, nil
+}
@@ -1,7 +1,9 @@
 package cli
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/mikeunge/argparser"
 	"github.com/pterm/pterm"
@@ -14,6 +16,13 @@ type App struct {
 	Version     string
 	Author      string
 	Github      string
+	Commands    []Command
+}
+
+// Command describes a positional (sub-)command like `sshman db migrate`.
+type Command struct {
+	Name string
+	Help string
 }
 
 func (app *App) New() (map[string]interface{}, map[string]*bool, error) {
@@ -29,16 +38,26 @@ func (app *App) New() (map[string]interface{}, map[string]*bool, error) {
 	args["new"], argsFound["new"] = parser.Flag("-n", "--new", &argparser.Options{Required: false, Help: "Create a new SSH profile."})
 	args["no-encryption"], argsFound["no-encryption"] = parser.Flag("", "--no-encrypt", &argparser.Options{Required: false, Help: "Don't encrypt the profile."})
 	args["update"], argsFound["update"] = parser.Flag("-u", "--update", &argparser.Options{Required: false, Help: "Update an SSH profile."})
+	args["clone"], argsFound["clone"] = parser.String("", "--clone", &argparser.Options{Required: false, Help: "Copy an SSH profile (by alias or id) under a new alias."})
+	args["encrypt"], argsFound["encrypt"] = parser.String("", "--encrypt", &argparser.Options{Required: false, Help: "Encrypt the stored secret of an SSH profile (by alias or id)."})
+	args["encrypt-all"], argsFound["encrypt-all"] = parser.Flag("", "--encrypt-all", &argparser.Options{Required: false, Help: "Encrypt the stored secrets of all unencrypted SSH profiles with the same key."})
+	args["decrypt-store"], argsFound["decrypt-store"] = parser.String("", "--decrypt-store", &argparser.Options{Required: false, Help: "Store the secret of an encrypted SSH profile (by alias or id) unencrypted."})
 	args["delete"], argsFound["delete"] = parser.Flag("-d", "--delete", &argparser.Options{Required: false, Help: "Delete SSH profiles."})
+	args["trash"], argsFound["trash"] = parser.Flag("", "--trash", &argparser.Options{Required: false, Help: "List deleted SSH profiles."})
+	args["restore"], argsFound["restore"] = parser.Flag("", "--restore", &argparser.Options{Required: false, Help: "Restore deleted SSH profiles from the trash."})
+	args["purge"], argsFound["purge"] = parser.Flag("", "--purge", &argparser.Options{Required: false, Help: "Permanently remove profiles that are in the trash for longer than the configured retention."})
 	args["export"], argsFound["export"] = parser.Flag("", "--export", &argparser.Options{Required: false, Help: "Export profiles."})
-	args["import"], argsFound["import"] = parser.String("", "--import", &argparser.Options{Required: false, Help: "Import profiles."})
+	args["import"], argsFound["import"] = parser.String("", "--import", &argparser.Options{Required: false, Help: "Import profiles (from a CSV export or a bundle)."})
+	args["bundle"], argsFound["bundle"] = parser.Flag("", "--bundle", &argparser.Options{Required: false, Help: "Export the profiles as a bundle encrypted with a passphrase. (used for export)"})
 	args["scp"], argsFound["scp"] = parser.Flag("", "--scp", &argparser.Options{Required: false, Help: "Copy files to/from remote server using profile."})
 	args["from"], argsFound["from"] = parser.String("", "--from", &argparser.Options{Required: false, Help: "Source file path for SCP operation (format: /local/path or profile_alias:/remote/path)."})
-	args["to"], argsFound["to"] = parser.String("", "--to", &argparser.Options{Required: false, Help: "Destination file path for SCP operation (format: /local/path or profile_alias:/remote/path)."})
+	args["to"], argsFound["to"] = parser.String("", "--to", &argparser.Options{Required: false, Help: "Destination file path for SCP operation (format: /local/path or profile_alias:/remote/path) or revision for rollback."})
 
 	args["alias"], argsFound["alias"] = parser.String("-a", "--alias", &argparser.Options{Required: false, Help: "Provide an alias to directly access."})
 	args["id"], argsFound["id"] = parser.Number("-i", "--id", &argparser.Options{Required: false, Help: "Provide an id for directly accessing."})
 	args["decrypt"], argsFound["decrypt"] = parser.Flag("", "--decrypt", &argparser.Options{Required: false, Help: "Decrypt the profile. (used for export)"})
+	args["tag"], argsFound["tag"] = parser.MultiString("-t", "--tag", &argparser.Options{Required: false, Help: "Filter profiles by tag(s). (used for list, connect, delete and export)"})
+	args["status"], argsFound["status"] = parser.Flag("", "--status", &argparser.Options{Required: false, Help: "Show the status instead of running the command. (used for db migrate)"})
 
 	err := parser.Parse()
 	if err != nil {
@@ -46,6 +65,13 @@ func (app *App) New() (map[string]interface{}, map[string]*bool, error) {
 		return args, argsFound, err
 	}
 
+	for _, arg := range os.Args {
+		if arg == "--help" {
+			app.printCommands()
+			os.Exit(0)
+		}
+	}
+
 	if *argsFound["version"] {
 		pterm.DefaultBasicText.Printf("v%s\n", app.Version)
 		os.Exit(0)
@@ -64,3 +90,35 @@ func (app *App) New() (map[string]interface{}, map[string]*bool, error) {
 	}
 	return args, argsFound, nil
 }
+
+// Subcommand returns the positional arguments that precede the first flag,
+// e.g. ["db", "migrate"] for `sshman db migrate --status`.
+func Subcommand() []string {
+	var sub []string
+
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		sub = append(sub, arg)
+	}
+	return sub
+}
+
+func (app *App) printCommands() {
+	if len(app.Commands) == 0 {
+		return
+	}
+
+	maxName := 0
+	for _, cmd := range app.Commands {
+		if len(cmd.Name) > maxName {
+			maxName = len(cmd.Name)
+		}
+	}
+
+	fmt.Printf("\nAvailable commands:\n")
+	for _, cmd := range app.Commands {
+		fmt.Printf("\t%s%s\t%s\n", cmd.Name, strings.Repeat(" ", maxName-len(cmd.Name)), cmd.Help)
+	}
+}
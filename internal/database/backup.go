@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mikeunge/sshman/pkg/helpers"
+)
+
+// Number of automatic backups that are kept if nothing else is configured
+const DefaultBackupRotation = 5
+
+// BackupDir returns the directory the backups are written to, next to the database.
+func (d *DB) BackupDir() string {
+	return filepath.Join(filepath.Dir(d.Path), "backups")
+}
+
+// Backup writes a consistent copy of the database to path.
+// It uses sqlite's online backup, so it's safe to run while another sshman instance is using the database.
+func (d *DB) Backup(path string) error {
+	if helpers.FileExists(path) {
+		return fmt.Errorf("%s already exists, not overwriting it", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	// The copy inherits the WAL mode of the database, a backup has to be a single self-contained file
+	if err = copyDatabase(dest, d.db); err == nil {
+		_, err = dest.Exec("PRAGMA journal_mode=DELETE;")
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// AutoBackup creates a backup before a destructive operation and removes the oldest automatic backups.
+// The returned path is empty if automatic backups are disabled.
+func (d *DB) AutoBackup(reason string) (string, error) {
+	keep := d.BackupRotation
+	if keep == 0 {
+		keep = DefaultBackupRotation
+	}
+	if keep < 0 {
+		return "", nil
+	}
+
+	path := filepath.Join(d.BackupDir(), fmt.Sprintf("auto-%s-%s.db", time.Now().Format("20060102-150405.000000"), reason))
+	if err := d.Backup(path); err != nil {
+		return "", err
+	}
+
+	// The timestamp is fixed width, so sorting by name sorts the backups by age
+	backups, err := filepath.Glob(filepath.Join(d.BackupDir(), "auto-*.db"))
+	if err != nil {
+		return path, err
+	}
+	slices.Sort(backups)
+	for len(backups) > keep {
+		if err = os.Remove(backups[0]); err != nil {
+			return path, err
+		}
+		backups = backups[1:]
+	}
+	return path, nil
+}
+
+// Restore replaces the content of the database with the backup at path.
+// Backups of a newer schema are refused, older ones are migrated after restoring them.
+func (d *DB) Restore(path string) (int, error) {
+	if !helpers.FileExists(path) {
+		return 0, fmt.Errorf("backup %s not found", path)
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := backupSchemaVersion(src)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid sshman backup, %s", path, err.Error())
+	}
+	if latest := LatestSchemaVersion(); version > latest {
+		return version, fmt.Errorf("backup schema version %d is newer than the supported version %d, please update sshman", version, latest)
+	}
+
+	if err = retryBusy(func() error { return copyDatabase(d.db, src) }); err != nil {
+		return version, err
+	}
+	return version, d.runMigrations()
+}
+
+// backupSchemaVersion returns the schema version of a database that isn't opened by sshman.
+// Databases created before migrations existed don't have a schema_version table, they are version 0.
+func backupSchemaVersion(db *sql.DB) (int, error) {
+	var tables int
+	var version sql.NullInt64
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name IN ('SSH_Profile', 'schema_version');").Scan(&tables); err != nil {
+		return 0, err
+	}
+
+	switch tables {
+	case 0:
+		return 0, fmt.Errorf("no profiles table found")
+	case 1:
+		return 0, nil
+	}
+
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version;").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
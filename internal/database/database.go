@@ -3,15 +3,25 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"strings"
+	"slices"
 	"time"
+)
+
+// WAL lets other instances read while one is writing, writers wait up to the busy timeout for each other.
+// Transactions take the write lock immediately, so they can't fail halfway through when upgrading their lock.
+const connectionOptions = "?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
 
-	"github.com/mikeunge/sshman/pkg/helpers"
+// How often and how long to wait when the database is still busy after the busy timeout
+const (
+	busyRetries    = 5
+	busyRetryDelay = 200 * time.Millisecond
 )
 
 type DB struct {
-	Path string
-	db   *sql.DB
+	Path           string
+	BackupRotation int  // number of automatic backups to keep, 0 uses the default and a negative value disables them
+	SkipMigrations bool // don't apply pending migrations on connect, db migrate applies them itself
+	db             *sql.DB
 }
 
 // Create an enum (SSHProfileType) because go doesn't provide it by default...
@@ -21,6 +31,8 @@ type SSHProfileAuthType int64
 const (
 	AuthTypePassword   SSHProfileAuthType = 0
 	AuthTypePrivateKey SSHProfileAuthType = 1
+	AuthTypeAgent      SSHProfileAuthType = 2 // the keys of the ssh-agent, nothing is stored
+	AuthTypeKeyboard   SSHProfileAuthType = 3 // keyboard-interactive, the server's prompts are answered when connecting
 )
 
 func GetNameFromAuthType(t SSHProfileAuthType) string {
@@ -28,77 +40,170 @@ func GetNameFromAuthType(t SSHProfileAuthType) string {
 		return "Password"
 	} else if t == AuthTypePrivateKey {
 		return "Private Key"
+	} else if t == AuthTypeAgent {
+		return "Agent"
+	} else if t == AuthTypeKeyboard {
+		return "Keyboard Interactive"
 	} else {
 		return "Unknown"
 	}
 }
 
+// StoresSecret reports whether profiles of the type need a password or private key, either stored or from a secret provider.
+func (t SSHProfileAuthType) StoresSecret() bool {
+	return t == AuthTypePassword || t == AuthTypePrivateKey
+}
+
 func GetAuthTypeFromName(s string) (SSHProfileAuthType, error) {
 	if s == "Password" {
 		return AuthTypePassword, nil
 	} else if s == "Private Key" {
 		return AuthTypePrivateKey, nil
+	} else if s == "Agent" {
+		return AuthTypeAgent, nil
+	} else if s == "Keyboard Interactive" {
+		return AuthTypeKeyboard, nil
 	} else {
 		return 0, fmt.Errorf("%s is not a valid authentication type", s)
 	}
 }
 
+// Default port used when a profile doesn't specify one
+const DefaultSSHPort = 22
+
+// Startup command of a profile that doesn't run the startup command of its template, an empty one is inherited
+const NoStartupCommand = "-"
+
 // SSH profile model
 type SSHProfile struct {
-	Id              int64
-	Alias           string
-	Host            string
-	User            string
-	Password        string
-	PrivateKey      []byte
-	StartupCommand  string
-	AuthType        SSHProfileAuthType
-	Encrypted       bool
-	CTime           time.Time
-	MTime           time.Time
+	Id             int64
+	Alias          string
+	Host           string
+	Port           int
+	User           string
+	Password       string
+	PrivateKey     []byte
+	StartupCommand string
+	Tags           []string
+	Env            map[string]string // sent to the server when a session starts
+	AuthType       SSHProfileAuthType
+	Encrypted      bool
+	KeyCheck       string // verifies the encryption key before decrypting, empty for profiles encrypted before it existed
+	SecretRef      string // "<provider>:<reference>" if the secret is resolved by a secret provider instead of being stored
+	AgentKey       string // SHA256 fingerprint of the ssh-agent key to use, empty offers all keys of the agent
+	TemplateId     int64  // 0 if the profile doesn't inherit from a template
+	IsTemplate     bool
+	CTime          time.Time
+	MTime          time.Time
+	DeletedAt      time.Time // only set for profiles in the trash
+}
+
+// SSHProfileSummary holds everything needed to list or select a profile, it never contains secrets.
+type SSHProfileSummary struct {
+	Id         int64
+	Alias      string
+	Host       string
+	Port       int
+	User       string
+	Tags       []string
+	AuthType   SSHProfileAuthType
+	Encrypted  bool
+	HasAuth    bool // false if the profile inherits the authentication from its template
+	TemplateId int64
+	IsTemplate bool
+	CTime      time.Time
+	MTime      time.Time
+	DeletedAt  time.Time // only set for profiles in the trash
+}
+
+func (p SSHProfile) Summary() SSHProfileSummary {
+	return SSHProfileSummary{
+		Id:         p.Id,
+		Alias:      p.Alias,
+		Host:       p.Host,
+		Port:       p.Port,
+		User:       p.User,
+		Tags:       slices.Clone(p.Tags),
+		AuthType:   p.AuthType,
+		Encrypted:  p.Encrypted,
+		HasAuth:    p.HasAuth(),
+		TemplateId: p.TemplateId,
+		IsTemplate: p.IsTemplate,
+		CTime:      p.CTime,
+		MTime:      p.MTime,
+		DeletedAt:  p.DeletedAt,
+	}
+}
+
+// HasAuth reports whether the profile has an authentication of its own, otherwise it inherits the one of its template.
+func (p SSHProfile) HasAuth() bool {
+	return len(p.Password) > 0 || len(p.PrivateKey) > 0 || len(p.SecretRef) > 0 || !p.AuthType.StoresSecret()
+}
+
+// String keeps the password and private key out of logs and error messages, even if a profile is formatted with %v.
+func (p SSHProfile) String() string {
+	return fmt.Sprintf("%s (%s@%s:%d)", p.Alias, p.User, p.Host, p.Port)
+}
+
+func (p SSHProfile) GoString() string {
+	return p.String()
 }
 
 func (d *DB) Connect() error {
 	var err error
-	var initDb = false
 
-	if !helpers.PathExists(d.Path) {
-		initDb = true
+	if d.db, err = sql.Open("sqlite3", d.Path+connectionOptions); err != nil {
+		return err
 	}
 
-	if d.db, err = sql.Open("sqlite3", d.Path); err != nil {
+	// Another instance could be switching the journal mode or migrating right now
+	if err = retryBusy(d.db.Ping); err != nil {
 		return err
 	}
 
-	if initDb {
-		if err := d.initDatabase(); err != nil {
-			return err
-		}
+	if d.SkipMigrations {
+		return nil
 	}
 
-	// Run migrations to ensure schema is up to date
-	if err := d.runMigrations(); err != nil {
-		return err
-	}
+	// Create or upgrade the schema, new databases start at version 0
+	return d.Migrate()
+}
 
-	return nil
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
-func (d *DB) runMigrations() error {
-	// Try to add the startupCommand column if it doesn't exist (migration)
-	_, err := d.db.Exec("ALTER TABLE SSH_Profile ADD COLUMN startupCommand TEXT DEFAULT '';")
-	// If this fails because column already exists, that's fine
-	if err != nil {
-		// Check if it's a duplicate column error - these are acceptable
-		errStr := err.Error()
-		if !strings.Contains(errStr, "duplicate column name") &&
-		   !strings.Contains(errStr, "already exists") &&
-		   !strings.Contains(errStr, "column already exists") {
-			// If it's not a "column already exists" error, return it
+// withTx runs fn inside a transaction, the transaction is rolled back if fn returns an error.
+// If the database is busy the whole transaction is retried, so fn must not have side effects outside of tx.
+func (d *DB) withTx(fn func(tx *sql.Tx) error) error {
+	return retryBusy(func() error {
+		tx, err := d.db.Begin()
+		if err != nil {
 			return err
 		}
+		defer tx.Rollback()
+
+		if err = fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// retryBusy runs fn again (with an increasing delay) as long as the database is busy.
+func retryBusy(fn func() error) error {
+	var err error
+
+	for i := 0; i < busyRetries; i++ {
+		if err = fn(); !isBusy(err) {
+			return err
+		}
+		time.Sleep(time.Duration(i+1) * busyRetryDelay)
 	}
-	return nil
+	return fmt.Errorf("the database is locked by another sshman instance, %s", err.Error())
 }
 
 func (d *DB) Disconnect() error {
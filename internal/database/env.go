@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+const (
+	QueryCreateEnvTable = `
+  CREATE TABLE IF NOT EXISTS SSH_Profile_Env (
+    profileId INTEGER NOT NULL REFERENCES SSH_Profile(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    value TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (profileId, name)
+  );`
+)
+
+// getProfileEnv returns the environment variables of a single profile.
+func (d *DB) getProfileEnv(profileId int64) (map[string]string, error) {
+	env, err := getEnvByProfile(d.db, "WHERE profileId=?", profileId)
+	if err != nil {
+		return nil, err
+	}
+	return env[profileId], nil
+}
+
+// getEnvByProfile returns the environment variables of all profiles matching the filter, mapped by profile id.
+func getEnvByProfile(q querier, filter string, args ...any) (map[int64]map[string]string, error) {
+	env := make(map[int64]map[string]string)
+
+	rows, err := q.Query("SELECT profileId, name, value FROM SSH_Profile_Env "+filter+";", args...)
+	if err != nil {
+		return env, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profileId int64
+		var name, value string
+		if err = rows.Scan(&profileId, &name, &value); err != nil {
+			return env, err
+		}
+		if env[profileId] == nil {
+			env[profileId] = make(map[string]string)
+		}
+		env[profileId][name] = value
+	}
+	return env, rows.Err()
+}
+
+// attachEnv loads the environment variables for every profile in the slice.
+func (d *DB) attachEnv(profiles []SSHProfile) error {
+	env, err := getEnvByProfile(d.db, "")
+	if err != nil {
+		return err
+	}
+	for i := range profiles {
+		profiles[i].Env = env[profiles[i].Id]
+	}
+	return nil
+}
+
+// setProfileEnv replaces the environment variables of a profile.
+func setProfileEnv(tx *sql.Tx, profileId int64, env map[string]string) error {
+	if _, err := tx.Exec("DELETE FROM SSH_Profile_Env WHERE profileId=?;", profileId); err != nil {
+		return err
+	}
+
+	for name, value := range env {
+		if _, err := tx.Exec("INSERT INTO SSH_Profile_Env (profileId, name, value) VALUES(?, ?, ?);", profileId, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeEnv serializes the environment variables for the revision table.
+func encodeEnv(env map[string]string) (string, error) {
+	if len(env) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(env)
+	return string(raw), err
+}
+
+func decodeEnv(raw string) (map[string]string, error) {
+	var env map[string]string
+
+	if len(raw) == 0 {
+		return env, nil
+	}
+	err := json.Unmarshal([]byte(raw), &env)
+	return env, err
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	QueryCreateConnectionHistoryTable = `
+  CREATE TABLE IF NOT EXISTS connection_history (
+    id INTEGER NOT NULL PRIMARY KEY,
+    profileId INTEGER NOT NULL REFERENCES SSH_Profile(id) ON DELETE CASCADE,
+    start DATETIME NOT NULL,
+    end DATETIME NOT NULL,
+    duration INTEGER NOT NULL DEFAULT 0,
+    exitStatus INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT ''
+  );
+  CREATE INDEX IF NOT EXISTS connection_history_profile ON connection_history (profileId, start);`
+)
+
+// A single (attempted) session with a profile
+type ConnectionHistory struct {
+	Id         int64
+	ProfileId  int64
+	Alias      string
+	Start      time.Time
+	End        time.Time
+	Duration   time.Duration
+	ExitStatus int
+	Error      string
+}
+
+// Aggregated connection information of a profile
+type ConnectionStats struct {
+	LastConnected time.Time
+	Count         int64
+}
+
+func (d *DB) CreateConnectionHistory(entry ConnectionHistory) (int64, error) {
+	res, err := d.db.Exec("INSERT INTO connection_history (profileId, start, end, duration, exitStatus, error) VALUES(?, ?, ?, ?, ?, ?);", entry.ProfileId, entry.Start.UTC(), entry.End.UTC(), entry.Duration.Milliseconds(), entry.ExitStatus, entry.Error)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetConnectionHistory returns the newest sessions first, a profileId of 0 returns the history of all profiles.
+func (d *DB) GetConnectionHistory(profileId int64, limit int) ([]ConnectionHistory, error) {
+	var history []ConnectionHistory
+	var rows *sql.Rows
+	var err error
+
+	query := "SELECT h.id, h.profileId, p.alias, h.start, h.end, h.duration, h.exitStatus, h.error FROM connection_history h JOIN SSH_Profile p ON p.id=h.profileId"
+	if profileId > 0 {
+		rows, err = d.db.Query(query+" WHERE h.profileId=? ORDER BY h.start DESC LIMIT ?;", profileId, limit)
+	} else {
+		rows, err = d.db.Query(query+" ORDER BY h.start DESC LIMIT ?;", limit)
+	}
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry ConnectionHistory
+		var duration int64
+		if err = rows.Scan(&entry.Id, &entry.ProfileId, &entry.Alias, &entry.Start, &entry.End, &duration, &entry.ExitStatus, &entry.Error); err != nil {
+			return history, err
+		}
+		entry.Duration = time.Duration(duration) * time.Millisecond
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// GetConnectionStats returns the last connection and the number of connections, mapped by profile id.
+func (d *DB) GetConnectionStats() (map[int64]ConnectionStats, error) {
+	stats := make(map[int64]ConnectionStats)
+
+	rows, err := d.db.Query("SELECT h.profileId, h.start, c.count FROM connection_history h JOIN (SELECT MAX(id) AS id, COUNT(*) AS count FROM connection_history GROUP BY profileId) c ON c.id=h.id;")
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profileId int64
+		var stat ConnectionStats
+		if err = rows.Scan(&profileId, &stat.LastConnected, &stat.Count); err != nil {
+			return stats, err
+		}
+		stats[profileId] = stat
+	}
+	return stats, rows.Err()
+}
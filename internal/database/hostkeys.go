@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	QueryCreateHostKeyTable = `
+  CREATE TABLE IF NOT EXISTS SSH_Profile_HostKey (
+    profileId INTEGER NOT NULL PRIMARY KEY REFERENCES SSH_Profile(id) ON DELETE CASCADE,
+    address TEXT NOT NULL,
+    keyType TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    ctime DATETIME DEFAULT CURRENT_TIMESTAMP
+  );`
+)
+
+// HostKey is the host key a profile's server presented on the first connect and the user trusted.
+type HostKey struct {
+	ProfileId   int64
+	Address     string // host:port the key was presented by, a profile pointing somewhere else has to trust the new server again
+	KeyType     string
+	Fingerprint string // SHA256 fingerprint as shown by ssh-keygen -l
+	CTime       time.Time
+}
+
+// GetHostKey returns the trusted host key of a profile, nil if none was stored yet.
+func (d *DB) GetHostKey(profileId int64) (*HostKey, error) {
+	key := HostKey{ProfileId: profileId}
+
+	row := d.db.QueryRow("SELECT address, keyType, fingerprint, ctime FROM SSH_Profile_HostKey WHERE profileId=?;", profileId)
+	if err := row.Scan(&key.Address, &key.KeyType, &key.Fingerprint, &key.CTime); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// SaveHostKey stores the trusted host key of a profile, replacing the previous one.
+func (d *DB) SaveHostKey(key HostKey) error {
+	_, err := d.db.Exec("INSERT OR REPLACE INTO SSH_Profile_HostKey (profileId, address, keyType, fingerprint) VALUES(?, ?, ?, ?);", key.ProfileId, key.Address, key.KeyType, key.Fingerprint)
+	return err
+}
+
+// DeleteHostKey forgets the trusted host key of a profile, it returns false if there was none.
+func (d *DB) DeleteHostKey(profileId int64) (bool, error) {
+	res, err := d.db.Exec("DELETE FROM SSH_Profile_HostKey WHERE profileId=?;", profileId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
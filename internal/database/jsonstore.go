@@ -0,0 +1,107 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/mikeunge/sshman/pkg/helpers"
+)
+
+// JSONStore keeps all data in a single JSON file, it doesn't require cgo.
+// The file is rewritten after every change. Changes hold an exclusive lock on the file Path.lock and reload the file first,
+// so several sshman processes can share the store without losing each other's changes.
+type JSONStore struct {
+	*MemoryStore
+	Path string
+}
+
+func NewJSONStore(path string) *JSONStore {
+	store := &JSONStore{MemoryStore: NewMemoryStore(), Path: path}
+	store.persist = store.write
+	store.lock = store.lockFile
+	return store
+}
+
+func (j *JSONStore) Connect() error {
+	data, err := j.load()
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.data = data
+	j.mu.Unlock()
+	return nil
+}
+
+// load reads the file, a missing file is an empty store.
+func (j *JSONStore) load() (memoryData, error) {
+	data := newMemoryData()
+	if !helpers.FileExists(j.Path) {
+		return data, nil
+	}
+
+	raw, err := helpers.ReadFile(j.Path)
+	if err != nil {
+		return data, err
+	}
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return data, fmt.Errorf("could not parse %s, %s", j.Path, err.Error())
+	}
+	if data.Revisions == nil {
+		data.Revisions = make(map[int64][]ProfileRevision)
+	}
+	return data, nil
+}
+
+// lockFile waits for the exclusive lock of the store and reloads the file, another process could have changed it.
+func (j *JSONStore) lockFile(data *memoryData) (func(), error) {
+	file, err := os.OpenFile(j.Path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the lock file of %s, %s", j.Path, err.Error())
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not lock %s, %s", j.Path, err.Error())
+	}
+	// Closing the file releases the lock
+	unlock := func() { file.Close() }
+
+	current, err := j.load()
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	*data = current
+	return unlock, nil
+}
+
+// write replaces the file atomically, so a crash can't leave a half written store behind.
+func (j *JSONStore) write(data *memoryData) error {
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.Path), filepath.Base(j.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.Path)
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Two processes sharing the file must not overwrite each other's changes with their outdated state.
+func TestJSONStoreKeepsChangesOfOtherProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshman.json")
+	first, second := NewJSONStore(path), NewJSONStore(path)
+	for _, store := range []*JSONStore{first, second} {
+		if err := store.Connect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := first.CreateSSHProfile(SSHProfile{Alias: "web", Host: "example.com", User: "root", Port: 22, AuthType: AuthTypePassword, Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.CreateSSHProfile(SSHProfile{Alias: "db", Host: "example.org", User: "root", Port: 22, AuthType: AuthTypePassword, Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := NewJSONStore(path)
+	if err := reopened.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := reopened.GetAllSSHProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("got %d profiles, expected both to be stored: %v", len(profiles), profiles)
+	}
+	if profiles[0].Id == profiles[1].Id {
+		t.Fatalf("both profiles got the id %d", profiles[0].Id)
+	}
+}
@@ -0,0 +1,710 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps all data in memory, nothing survives the process.
+// It is used for testing and as the base of the JSONStore.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data memoryData
+
+	// persist is called with the new state after every change, if it fails the change is reverted
+	persist func(data *memoryData) error
+	// lock is called before every change and guards it against other processes, it may replace the state with a newer one
+	lock func(data *memoryData) (unlock func(), err error)
+}
+
+// memoryData is the complete state of a MemoryStore, it is also the file format of the JSONStore.
+type memoryData struct {
+	NextProfileId int64                       `json:"nextProfileId"`
+	NextHistoryId int64                       `json:"nextHistoryId"`
+	Profiles      []SSHProfile                `json:"profiles"`
+	History       []ConnectionHistory         `json:"history"`
+	Revisions     map[int64][]ProfileRevision `json:"revisions"`
+	Vault         *Vault                      `json:"vault,omitempty"`
+	HostKeys      map[int64]HostKey           `json:"hostKeys,omitempty"`
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: newMemoryData()}
+}
+
+func newMemoryData() memoryData {
+	return memoryData{
+		NextProfileId: 1,
+		NextHistoryId: 1,
+		Revisions:     make(map[int64][]ProfileRevision),
+	}
+}
+
+func (m *MemoryStore) Connect() error {
+	return nil
+}
+
+func (m *MemoryStore) Disconnect() error {
+	return nil
+}
+
+// update applies fn to the state, the state is restored if fn or persisting the new state fails.
+func (m *MemoryStore) update(fn func(data *memoryData) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lock != nil {
+		unlock, err := m.lock(&m.data)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	snapshot := m.data.snapshot()
+
+	err := fn(&m.data)
+	if err == nil && m.persist != nil {
+		err = m.persist(&m.data)
+	}
+	if err != nil {
+		m.data = snapshot
+	}
+	return err
+}
+
+// read runs fn on the state while holding the lock. fn has to copy everything it returns (see copyProfile),
+// so returned values can't alias the store and only the records a query returns get copied.
+func (m *MemoryStore) read(fn func(data *memoryData) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fn(&m.data)
+}
+
+// snapshot copies the records of the state, so a failed update can be reverted.
+// Secrets, tags and environment variables are shared, the store never changes them in place but always replaces them.
+func (d *memoryData) snapshot() memoryData {
+	snapshot := *d
+	snapshot.Profiles = slices.Clone(d.Profiles)
+	snapshot.History = slices.Clone(d.History)
+	snapshot.Revisions = make(map[int64][]ProfileRevision, len(d.Revisions))
+	for id, revisions := range d.Revisions {
+		snapshot.Revisions[id] = slices.Clone(revisions)
+	}
+	if d.Vault != nil {
+		vault := *d.Vault
+		snapshot.Vault = &vault
+	}
+	snapshot.HostKeys = maps.Clone(d.HostKeys)
+	return snapshot
+}
+
+// copyProfile returns a profile that doesn't share any memory with p, callers may wipe its private key.
+func copyProfile(p SSHProfile) SSHProfile {
+	p.PrivateKey = slices.Clone(p.PrivateKey)
+	p.Tags = slices.Clone(p.Tags)
+	p.Env = maps.Clone(p.Env)
+	return p
+}
+
+func copyRevision(r ProfileRevision) ProfileRevision {
+	r.Profile = copyProfile(r.Profile)
+	return r
+}
+
+// profile returns the index of the active profile matching the predicate, -1 if there is none.
+func (d *memoryData) profile(match func(p *SSHProfile) bool) int {
+	for i := range d.Profiles {
+		if d.Profiles[i].DeletedAt.IsZero() && match(&d.Profiles[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *memoryData) aliasTaken(alias string, exceptId int64) error {
+	for _, p := range d.Profiles {
+		if p.Alias != alias || p.Id == exceptId {
+			continue
+		}
+		if !p.DeletedAt.IsZero() {
+			return fmt.Errorf("profile with alias '%s' already exists in the trash, restore or purge it first", alias)
+		}
+		return fmt.Errorf("profile with alias '%s' already exists", alias)
+	}
+	return nil
+}
+
+// templateExists is the foreign key check of the sqlite backend, an id of 0 means no template.
+func (d *memoryData) templateExists(templateId int64) error {
+	if templateId == 0 {
+		return nil
+	}
+	for _, p := range d.Profiles {
+		if p.Id == templateId {
+			return nil
+		}
+	}
+	return fmt.Errorf("template with id '%d' does not exist", templateId)
+}
+
+func (d *memoryData) recordRevision(profileId int64) error {
+	i := d.profile(func(p *SSHProfile) bool { return p.Id == profileId })
+	if i < 0 {
+		return fmt.Errorf("are you sure a profile with id '%d' exists?", profileId)
+	}
+
+	revision := 1
+	if revisions := d.Revisions[profileId]; len(revisions) > 0 {
+		revision = revisions[len(revisions)-1].Revision + 1
+	}
+
+	profile := d.Profiles[i]
+	profile.Tags = slices.Clone(profile.Tags)
+	profile.Env = maps.Clone(profile.Env)
+	profile.PrivateKey = slices.Clone(profile.PrivateKey)
+	d.Revisions[profileId] = append(d.Revisions[profileId], ProfileRevision{
+		Revision: revision,
+		Profile:  profile,
+		CTime:    time.Now().UTC(),
+	})
+	return nil
+}
+
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(tags)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// normalizeEnv copies the variables, an empty environment is stored as nil like in the sqlite backend.
+func normalizeEnv(env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return nil
+	}
+	return maps.Clone(env)
+}
+
+func (m *MemoryStore) CreateSSHProfile(profile SSHProfile) (int64, error) {
+	var id int64
+
+	err := m.update(func(d *memoryData) error {
+		var err error
+		id, err = d.createProfile(profile)
+		return err
+	})
+	return id, err
+}
+
+func (m *MemoryStore) CreateSSHProfiles(profiles []SSHProfile) ([]int64, error) {
+	var ids []int64
+
+	err := m.update(func(d *memoryData) error {
+		for _, profile := range profiles {
+			id, err := d.createProfile(profile)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (d *memoryData) createProfile(profile SSHProfile) (int64, error) {
+	if err := d.aliasTaken(profile.Alias, 0); err != nil {
+		return 0, err
+	}
+	if err := d.templateExists(profile.TemplateId); err != nil {
+		return 0, err
+	}
+
+	id := d.NextProfileId
+	d.NextProfileId++
+
+	now := time.Now().UTC()
+	profile.Id = id
+	profile.PrivateKey = slices.Clone(profile.PrivateKey)
+	profile.Tags = normalizeTags(profile.Tags)
+	profile.Env = normalizeEnv(profile.Env)
+	profile.CTime = now
+	profile.MTime = now
+	profile.DeletedAt = time.Time{}
+	d.Profiles = append(d.Profiles, profile)
+	return id, nil
+}
+
+func (m *MemoryStore) GetSSHProfileById(id int64) (SSHProfile, error) {
+	var profile SSHProfile
+
+	err := m.read(func(d *memoryData) error {
+		i := d.profile(func(p *SSHProfile) bool { return p.Id == id })
+		if i < 0 {
+			return sql.ErrNoRows
+		}
+		profile = copyProfile(d.Profiles[i])
+		return nil
+	})
+	return profile, err
+}
+
+func (m *MemoryStore) GetSSHProfileByAlias(alias string) (SSHProfile, error) {
+	var profile SSHProfile
+
+	err := m.read(func(d *memoryData) error {
+		i := d.profile(func(p *SSHProfile) bool { return p.Alias == alias })
+		if i < 0 {
+			return sql.ErrNoRows
+		}
+		profile = copyProfile(d.Profiles[i])
+		return nil
+	})
+	return profile, err
+}
+
+func (m *MemoryStore) GetSSHProfilesById(ids []int64) ([]SSHProfile, error) {
+	var profiles []SSHProfile
+
+	err := m.read(func(d *memoryData) error {
+		for _, p := range d.Profiles {
+			if p.DeletedAt.IsZero() && slices.Contains(ids, p.Id) {
+				profiles = append(profiles, copyProfile(p))
+			}
+		}
+		return nil
+	})
+	return profiles, err
+}
+
+func (m *MemoryStore) GetAllSSHProfiles() ([]SSHProfile, error) {
+	var profiles []SSHProfile
+
+	err := m.read(func(d *memoryData) error {
+		for _, p := range d.Profiles {
+			if p.DeletedAt.IsZero() {
+				profiles = append(profiles, copyProfile(p))
+			}
+		}
+		return nil
+	})
+	return profiles, err
+}
+
+func (m *MemoryStore) GetSSHProfileSummaries() ([]SSHProfileSummary, error) {
+	return m.summaries(func(p *SSHProfile) bool { return p.DeletedAt.IsZero() }), nil
+}
+
+// summaries only copies the summary fields, the secrets never leave the store.
+func (m *MemoryStore) summaries(match func(p *SSHProfile) bool) []SSHProfileSummary {
+	var summaries []SSHProfileSummary
+
+	m.read(func(d *memoryData) error {
+		for i := range d.Profiles {
+			if match(&d.Profiles[i]) {
+				summaries = append(summaries, d.Profiles[i].Summary())
+			}
+		}
+		return nil
+	})
+	return summaries
+}
+
+func (m *MemoryStore) UpdateSSHProfileById(id int64, updatedProfile SSHProfile) error {
+	return m.update(func(d *memoryData) error {
+		if err := d.recordRevision(id); err != nil {
+			return err
+		}
+		if err := d.aliasTaken(updatedProfile.Alias, id); err != nil {
+			return err
+		}
+		if err := d.templateExists(updatedProfile.TemplateId); err != nil {
+			return err
+		}
+
+		p := &d.Profiles[d.profile(func(p *SSHProfile) bool { return p.Id == id })]
+		p.Alias = updatedProfile.Alias
+		p.Host = updatedProfile.Host
+		p.Port = updatedProfile.Port
+		p.User = updatedProfile.User
+		if updatedProfile.AuthType == AuthTypePrivateKey {
+			p.PrivateKey = slices.Clone(updatedProfile.PrivateKey)
+		} else {
+			p.Password = updatedProfile.Password
+		}
+		p.StartupCommand = updatedProfile.StartupCommand
+		p.AuthType = updatedProfile.AuthType
+		p.Encrypted = updatedProfile.Encrypted
+		p.KeyCheck = updatedProfile.KeyCheck
+		p.SecretRef = updatedProfile.SecretRef
+		p.AgentKey = updatedProfile.AgentKey
+		p.TemplateId = updatedProfile.TemplateId
+		p.Tags = normalizeTags(updatedProfile.Tags)
+		p.Env = normalizeEnv(updatedProfile.Env)
+		p.MTime = time.Now().UTC()
+		return nil
+	})
+}
+
+func (m *MemoryStore) DeleteSSHProfileById(id int64) error {
+	return m.update(func(d *memoryData) error {
+		return d.deleteProfile(id)
+	})
+}
+
+func (m *MemoryStore) DeleteSSHProfilesById(ids []int64) error {
+	return m.update(func(d *memoryData) error {
+		for _, id := range ids {
+			if err := d.deleteProfile(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *memoryData) deleteProfile(id int64) error {
+	i := d.profile(func(p *SSHProfile) bool { return p.Id == id })
+	if i < 0 {
+		return fmt.Errorf("are you sure a profile with id '%d' exists?", id)
+	}
+
+	// Profiles in the trash count as well, they would lose their settings once the template is purged
+	children := 0
+	for _, p := range d.Profiles {
+		if p.TemplateId == id {
+			children++
+		}
+	}
+	if children > 0 {
+		return fmt.Errorf("profile with id '%d' is a template used by %d profile(s), delete or purge them first", id, children)
+	}
+	d.Profiles[i].DeletedAt = time.Now().UTC()
+	return nil
+}
+
+func (m *MemoryStore) GetDeletedSSHProfiles() ([]SSHProfileSummary, error) {
+	summaries := m.summaries(func(p *SSHProfile) bool { return !p.DeletedAt.IsZero() })
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].DeletedAt.After(summaries[j].DeletedAt) })
+	return summaries, nil
+}
+
+func (m *MemoryStore) RestoreSSHProfileById(id int64) error {
+	return m.update(func(d *memoryData) error {
+		for i := range d.Profiles {
+			if d.Profiles[i].Id == id && !d.Profiles[i].DeletedAt.IsZero() {
+				d.Profiles[i].DeletedAt = time.Time{}
+				return nil
+			}
+		}
+		return fmt.Errorf("are you sure a profile with id '%d' is in the trash?", id)
+	})
+}
+
+func (m *MemoryStore) PurgeDeletedSSHProfiles(deletedBefore time.Time) (int64, error) {
+	var purged int64
+
+	err := m.update(func(d *memoryData) error {
+		var purgedIds []int64
+		d.Profiles = slices.DeleteFunc(d.Profiles, func(p SSHProfile) bool {
+			if !p.DeletedAt.IsZero() && !p.DeletedAt.After(deletedBefore) {
+				purgedIds = append(purgedIds, p.Id)
+				return true
+			}
+			return false
+		})
+
+		// Same as the cascading deletes of the sqlite backend
+		d.History = slices.DeleteFunc(d.History, func(h ConnectionHistory) bool { return slices.Contains(purgedIds, h.ProfileId) })
+		for _, id := range purgedIds {
+			delete(d.Revisions, id)
+			delete(d.HostKeys, id)
+		}
+		purged = int64(len(purgedIds))
+		return nil
+	})
+	return purged, err
+}
+
+func (m *MemoryStore) CreateConnectionHistory(entry ConnectionHistory) (int64, error) {
+	var id int64
+
+	err := m.update(func(d *memoryData) error {
+		id = d.NextHistoryId
+		d.NextHistoryId++
+
+		entry.Id = id
+		entry.Alias = ""
+		entry.Start = entry.Start.UTC()
+		entry.End = entry.End.UTC()
+		d.History = append(d.History, entry)
+		return nil
+	})
+	return id, err
+}
+
+func (m *MemoryStore) GetConnectionHistory(profileId int64, limit int) ([]ConnectionHistory, error) {
+	var history []ConnectionHistory
+
+	err := m.read(func(d *memoryData) error {
+		aliases := make(map[int64]string)
+		for _, p := range d.Profiles {
+			aliases[p.Id] = p.Alias
+		}
+
+		for _, entry := range d.History {
+			if profileId > 0 && entry.ProfileId != profileId {
+				continue
+			}
+			entry.Alias = aliases[entry.ProfileId]
+			history = append(history, entry)
+		}
+		return nil
+	})
+
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Start.After(history[j].Start) })
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, err
+}
+
+func (m *MemoryStore) GetConnectionStats() (map[int64]ConnectionStats, error) {
+	stats := make(map[int64]ConnectionStats)
+
+	err := m.read(func(d *memoryData) error {
+		for _, entry := range d.History {
+			stat := stats[entry.ProfileId]
+			stat.Count++
+			if entry.Start.After(stat.LastConnected) {
+				stat.LastConnected = entry.Start
+			}
+			stats[entry.ProfileId] = stat
+		}
+		return nil
+	})
+	return stats, err
+}
+
+func (m *MemoryStore) GetProfileRevisions(profileId int64) ([]ProfileRevision, error) {
+	var revisions []ProfileRevision
+
+	err := m.read(func(d *memoryData) error {
+		for _, r := range d.Revisions[profileId] {
+			revisions = append(revisions, copyRevision(r))
+		}
+		slices.Reverse(revisions)
+		return nil
+	})
+	return revisions, err
+}
+
+func (m *MemoryStore) RollbackSSHProfile(profileId int64, revision int) error {
+	return m.update(func(d *memoryData) error {
+		var target *ProfileRevision
+		for i := range d.Revisions[profileId] {
+			if d.Revisions[profileId][i].Revision == revision {
+				target = &d.Revisions[profileId][i]
+				break
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("revision %d does not exist", revision)
+		}
+		restored := target.Profile
+		if restored.TemplateId != 0 {
+			i := slices.IndexFunc(d.Profiles, func(p SSHProfile) bool { return p.Id == restored.TemplateId })
+			if i < 0 {
+				return revisionTemplateError(revision, false)
+			} else if !d.Profiles[i].DeletedAt.IsZero() {
+				return revisionTemplateError(revision, true)
+			}
+		}
+
+		if err := d.recordRevision(profileId); err != nil {
+			return err
+		}
+		if err := d.aliasTaken(restored.Alias, profileId); err != nil {
+			return err
+		}
+
+		p := &d.Profiles[d.profile(func(p *SSHProfile) bool { return p.Id == profileId })]
+		p.Alias = restored.Alias
+		p.Host = restored.Host
+		p.Port = restored.Port
+		p.User = restored.User
+		p.Password = restored.Password
+		p.PrivateKey = restored.PrivateKey
+		p.StartupCommand = restored.StartupCommand
+		p.AuthType = restored.AuthType
+		p.Encrypted = restored.Encrypted
+		p.KeyCheck = restored.KeyCheck
+		p.SecretRef = restored.SecretRef
+		p.AgentKey = restored.AgentKey
+		p.TemplateId = restored.TemplateId
+		p.Tags = restored.Tags
+		p.Env = restored.Env
+		p.MTime = time.Now().UTC()
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetVault() (*Vault, error) {
+	var vault *Vault
+
+	err := m.read(func(d *memoryData) error {
+		if d.Vault != nil {
+			v := *d.Vault
+			v.Salt = slices.Clone(v.Salt)
+			vault = &v
+		}
+		return nil
+	})
+	return vault, err
+}
+
+func (m *MemoryStore) GetEncryptedSSHProfiles() ([]SSHProfile, error) {
+	var profiles []SSHProfile
+
+	err := m.read(func(d *memoryData) error {
+		for _, p := range d.Profiles {
+			if p.Encrypted && (len(p.Password) > 0 || len(p.PrivateKey) > 0) {
+				profiles = append(profiles, copyProfile(p))
+			}
+		}
+		return nil
+	})
+	return profiles, err
+}
+
+// GetEncryptedRevisions returns every revision with an encrypted secret, including the ones of profiles in the trash.
+func (m *MemoryStore) GetEncryptedRevisions() ([]ProfileRevision, error) {
+	var revisions []ProfileRevision
+
+	err := m.read(func(d *memoryData) error {
+		for _, profileRevisions := range d.Revisions {
+			for _, r := range profileRevisions {
+				if r.Profile.Encrypted && (len(r.Profile.Password) > 0 || len(r.Profile.PrivateKey) > 0) {
+					revisions = append(revisions, copyRevision(r))
+				}
+			}
+		}
+		return nil
+	})
+	return revisions, err
+}
+
+func (m *MemoryStore) SaveVault(vault *Vault, profiles []SSHProfile, revisions []ProfileRevision) error {
+	return m.update(func(d *memoryData) error {
+		d.Vault = nil
+		if vault != nil {
+			v := *vault
+			v.CTime = time.Now().UTC()
+			d.Vault = &v
+		}
+		if err := d.updateSecrets(profiles); err != nil {
+			return err
+		}
+		if err := d.updateRevisionSecrets(revisions); err != nil {
+			return err
+		}
+		d.deleteEncryptedRevisions(revisions)
+		return nil
+	})
+}
+
+func (m *MemoryStore) UpdateSSHProfileSecrets(profiles []SSHProfile, revisions []ProfileRevision) error {
+	return m.update(func(d *memoryData) error {
+		if err := d.updateSecrets(profiles); err != nil {
+			return err
+		}
+		return d.updateRevisionSecrets(revisions)
+	})
+}
+
+func (d *memoryData) updateSecrets(profiles []SSHProfile) error {
+	for _, profile := range profiles {
+		i := slices.IndexFunc(d.Profiles, func(p SSHProfile) bool { return p.Id == profile.Id })
+		if i < 0 {
+			return fmt.Errorf("are you sure a profile with id '%d' exists?", profile.Id)
+		}
+		d.Profiles[i].Password = profile.Password
+		d.Profiles[i].PrivateKey = slices.Clone(profile.PrivateKey)
+		d.Profiles[i].Encrypted = profile.Encrypted
+		d.Profiles[i].KeyCheck = profile.KeyCheck
+	}
+	return nil
+}
+
+func (d *memoryData) updateRevisionSecrets(revisions []ProfileRevision) error {
+	for _, revision := range revisions {
+		profileRevisions := d.Revisions[revision.Profile.Id]
+		i := slices.IndexFunc(profileRevisions, func(r ProfileRevision) bool { return r.Revision == revision.Revision })
+		if i < 0 {
+			return fmt.Errorf("revision %d of the profile with id '%d' doesn't exist", revision.Revision, revision.Profile.Id)
+		}
+		profileRevisions[i].Profile.Password = revision.Profile.Password
+		profileRevisions[i].Profile.PrivateKey = slices.Clone(revision.Profile.PrivateKey)
+		profileRevisions[i].Profile.Encrypted = revision.Profile.Encrypted
+		profileRevisions[i].Profile.KeyCheck = revision.Profile.KeyCheck
+	}
+	return nil
+}
+
+// deleteEncryptedRevisions deletes every revision with an encrypted secret that isn't one of kept.
+func (d *memoryData) deleteEncryptedRevisions(kept []ProfileRevision) {
+	for profileId := range d.Revisions {
+		d.Revisions[profileId] = slices.DeleteFunc(d.Revisions[profileId], func(r ProfileRevision) bool {
+			keep := slices.ContainsFunc(kept, func(k ProfileRevision) bool { return k.Profile.Id == profileId && k.Revision == r.Revision })
+			return !keep && r.Profile.Encrypted && (len(r.Profile.Password) > 0 || len(r.Profile.PrivateKey) > 0)
+		})
+	}
+}
+
+func (m *MemoryStore) GetHostKey(profileId int64) (*HostKey, error) {
+	var key *HostKey
+
+	err := m.read(func(d *memoryData) error {
+		if k, ok := d.HostKeys[profileId]; ok {
+			key = &k
+		}
+		return nil
+	})
+	return key, err
+}
+
+func (m *MemoryStore) SaveHostKey(key HostKey) error {
+	return m.update(func(d *memoryData) error {
+		if d.profile(func(p *SSHProfile) bool { return p.Id == key.ProfileId }) < 0 {
+			return fmt.Errorf("are you sure a profile with id '%d' exists?", key.ProfileId)
+		}
+		if d.HostKeys == nil {
+			d.HostKeys = make(map[int64]HostKey)
+		}
+		key.CTime = time.Now().UTC()
+		d.HostKeys[key.ProfileId] = key
+		return nil
+	})
+}
+
+func (m *MemoryStore) DeleteHostKey(profileId int64) (bool, error) {
+	var deleted bool
+
+	err := m.update(func(d *memoryData) error {
+		_, deleted = d.HostKeys[profileId]
+		delete(d.HostKeys, profileId)
+		return nil
+	})
+	return deleted, err
+}
@@ -0,0 +1,270 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	QueryCreateSchemaVersionTable = `
+  CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER NOT NULL PRIMARY KEY,
+    description TEXT NOT NULL,
+    applied DATETIME DEFAULT CURRENT_TIMESTAMP
+  );`
+)
+
+// migration describes a single, numbered schema change.
+// Versions must be unique and strictly increasing, migrations are never edited once released.
+type migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// MigrationStatus is used to report the state of a migration to the user.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// All known migrations, in order. Append new migrations to the end of the list.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create SSH_Profile table",
+		Up:          execStatements(QueryCreateTable),
+	},
+	{
+		Version:     2,
+		Description: "add startupCommand column to SSH_Profile",
+		Up:          addColumn("SSH_Profile", "startupCommand", "TEXT DEFAULT ''"),
+	},
+	{
+		Version:     3,
+		Description: "add port column to SSH_Profile",
+		Up:          execStatements("ALTER TABLE SSH_Profile ADD COLUMN port INTEGER NOT NULL DEFAULT 22;"),
+	},
+	{
+		Version:     4,
+		Description: "create Tag and SSH_Profile_Tag tables",
+		Up:          execStatements(QueryCreateTagTables),
+	},
+	{
+		Version:     5,
+		Description: "create connection_history table",
+		Up:          execStatements(QueryCreateConnectionHistoryTable),
+	},
+	{
+		Version:     6,
+		Description: "add deletedAt column to SSH_Profile for the trash",
+		Up:          execStatements("ALTER TABLE SSH_Profile ADD COLUMN deletedAt DATETIME;"),
+	},
+	{
+		Version:     7,
+		Description: "create SSH_Profile_Revision table",
+		Up:          execStatements(QueryCreateRevisionTable),
+	},
+	{
+		Version:     8,
+		Description: "create SSH_Profile_Env table and add env column to SSH_Profile_Revision",
+		Up: func(tx *sql.Tx) error {
+			if err := execStatements(QueryCreateEnvTable)(tx); err != nil {
+				return err
+			}
+			return addColumn("SSH_Profile_Revision", "env", "TEXT NOT NULL DEFAULT ''")(tx)
+		},
+	},
+	{
+		Version:     9,
+		Description: "add template columns to SSH_Profile and SSH_Profile_Revision",
+		Up: execStatements(
+			"ALTER TABLE SSH_Profile ADD COLUMN isTemplate BOOLEAN NOT NULL DEFAULT 0;",
+			"ALTER TABLE SSH_Profile ADD COLUMN templateId INTEGER REFERENCES SSH_Profile(id);",
+			"ALTER TABLE SSH_Profile_Revision ADD COLUMN templateId INTEGER;",
+		),
+	},
+	{
+		Version:     10,
+		Description: "create Vault table",
+		Up:          execStatements(QueryCreateVaultTable),
+	},
+	{
+		Version:     11,
+		Description: "add keyCheck column to SSH_Profile and SSH_Profile_Revision",
+		Up: execStatements(
+			"ALTER TABLE SSH_Profile ADD COLUMN keyCheck TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE SSH_Profile_Revision ADD COLUMN keyCheck TEXT NOT NULL DEFAULT '';",
+		),
+	},
+	{
+		Version:     12,
+		Description: "add secretRef column to SSH_Profile and SSH_Profile_Revision",
+		Up: execStatements(
+			"ALTER TABLE SSH_Profile ADD COLUMN secretRef TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE SSH_Profile_Revision ADD COLUMN secretRef TEXT NOT NULL DEFAULT '';",
+		),
+	},
+	{
+		Version:     13,
+		Description: "create SSH_Profile_HostKey table",
+		Up:          execStatements(QueryCreateHostKeyTable),
+	},
+	{
+		Version:     14,
+		Description: "add agentKey column to SSH_Profile and SSH_Profile_Revision",
+		Up: execStatements(
+			"ALTER TABLE SSH_Profile ADD COLUMN agentKey TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE SSH_Profile_Revision ADD COLUMN agentKey TEXT NOT NULL DEFAULT '';",
+		),
+	},
+}
+
+// execStatements returns a migration step that executes the provided statements in order.
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn returns a migration step that adds a column, unless it already exists.
+// Databases created before the migration framework existed may already contain the column.
+func addColumn(table string, column string, definition string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?;", table, column).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+		return err
+	}
+}
+
+// LatestSchemaVersion returns the schema version this build of sshman expects.
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// hasSchemaVersionTable checks for the schema_version table without creating it, so reading the version never writes.
+func hasSchemaVersionTable(q querier) (bool, error) {
+	var tables int
+	err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_version';").Scan(&tables)
+	return tables > 0, err
+}
+
+// SchemaVersion returns the currently applied schema version, databases without a schema_version table are version 0.
+func (d *DB) SchemaVersion() (int, error) {
+	var version sql.NullInt64
+
+	if exists, err := hasSchemaVersionTable(d.db); err != nil || !exists {
+		return 0, err
+	}
+	if err := d.db.QueryRow("SELECT MAX(version) FROM schema_version;").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// runMigrations applies all pending migrations inside a single transaction.
+// Either every pending migration is applied or none is.
+func (d *DB) runMigrations() error {
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d, please update sshman", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(QueryCreateSchemaVersionTable); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := m.Up(tx); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %s", m.Version, m.Description, err.Error())
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version (version, description) VALUES(?, ?);", m.Version, m.Description); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Migrate applies all pending migrations, retrying while another instance holds the database.
+func (d *DB) Migrate() error {
+	return retryBusy(d.runMigrations)
+}
+
+// MigrationStatus returns every known migration together with its applied state.
+func (d *DB) MigrationStatus() ([]MigrationStatus, error) {
+	var status []MigrationStatus
+
+	applied, err := appliedMigrations(d.db)
+	if err != nil {
+		return status, err
+	}
+
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		status = append(status, MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     ok,
+			AppliedAt:   appliedAt,
+		})
+	}
+	return status, nil
+}
+
+// appliedMigrations returns when each applied migration was applied, nothing is applied without a schema_version table.
+func appliedMigrations(q querier) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	if exists, err := hasSchemaVersionTable(q); err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := q.Query("SELECT version, applied FROM schema_version;")
+	if err != nil {
+		return applied, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return applied, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Reading the schema version of a database from before migrations existed must not create the schema_version table.
+func TestSchemaVersionIsReadOnly(t *testing.T) {
+	d := &DB{Path: filepath.Join(t.TempDir(), "sshman.db"), SkipMigrations: true}
+	if err := d.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Disconnect()
+
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("got schema version %d, expected 0", version)
+	}
+	status, err := d.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != len(migrations) || status[0].Applied {
+		t.Fatalf("expected every migration to be pending, got %v", status)
+	}
+
+	if exists, _ := hasSchemaVersionTable(d.db); exists {
+		t.Fatal("reading the schema version created the schema_version table")
+	}
+
+	if err = d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ = d.SchemaVersion(); version != LatestSchemaVersion() {
+		t.Fatalf("got schema version %d after migrating, expected %d", version, LatestSchemaVersion())
+	}
+}
@@ -7,46 +7,120 @@ import (
 	"time"
 )
 
+// Columns selected for every full profile query, keep in sync with scanProfile.
+const profileColumns = "id, alias, host, port, user, password, privateKey, COALESCE(startupCommand, ''), type, encrypted, keyCheck, secretRef, agentKey, COALESCE(templateId, 0), isTemplate, ctime, mtime"
+
+// Columns selected for profile summaries, keep in sync with scanSummary and SSHProfile.HasAuth (types 2 and 3 don't store a secret).
+const summaryColumns = "id, alias, host, port, user, type, encrypted, COALESCE(length(password), 0) + COALESCE(length(privateKey), 0) + length(secretRef) > 0 OR type IN (2, 3), COALESCE(templateId, 0), isTemplate, ctime, mtime"
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProfile(row scanner, profile *SSHProfile) error {
+	return row.Scan(&profile.Id, &profile.Alias, &profile.Host, &profile.Port, &profile.User, &profile.Password, &profile.PrivateKey, &profile.StartupCommand, &profile.AuthType, &profile.Encrypted, &profile.KeyCheck, &profile.SecretRef, &profile.AgentKey, &profile.TemplateId, &profile.IsTemplate, &profile.CTime, &profile.MTime)
+}
+
+func scanSummary(row scanner, summary *SSHProfileSummary, dest ...any) error {
+	return row.Scan(append([]any{&summary.Id, &summary.Alias, &summary.Host, &summary.Port, &summary.User, &summary.AuthType, &summary.Encrypted, &summary.HasAuth, &summary.TemplateId, &summary.IsTemplate, &summary.CTime, &summary.MTime}, dest...)...)
+}
+
 func (d *DB) CreateSSHProfile(profile SSHProfile) (int64, error) {
-	res, err := d.db.Exec("INSERT INTO SSH_Profile (alias, host, user, password, privateKey, startupCommand, type, encrypted) VALUES(?, ?, ?, ?, ?, ?, ?, ?);", profile.Alias, profile.Host, profile.User, profile.Password, profile.PrivateKey, profile.StartupCommand, profile.AuthType, profile.Encrypted)
+	var id int64
+
+	err := d.withTx(func(tx *sql.Tx) error {
+		var err error
+		id, err = createProfile(tx, profile)
+		return err
+	})
+	return id, err
+}
+
+// CreateSSHProfiles creates all profiles in a single transaction, if one of them fails none is created.
+func (d *DB) CreateSSHProfiles(profiles []SSHProfile) ([]int64, error) {
+	var ids []int64
+
+	err := d.withTx(func(tx *sql.Tx) error {
+		ids = nil
+		for _, profile := range profiles {
+			id, err := createProfile(tx, profile)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func createProfile(tx *sql.Tx, profile SSHProfile) (int64, error) {
+	res, err := tx.Exec("INSERT INTO SSH_Profile (alias, host, port, user, password, privateKey, startupCommand, type, encrypted, keyCheck, secretRef, agentKey, templateId, isTemplate) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);", profile.Alias, profile.Host, profile.Port, profile.User, profile.Password, profile.PrivateKey, profile.StartupCommand, profile.AuthType, profile.Encrypted, profile.KeyCheck, profile.SecretRef, profile.AgentKey, nullableId(profile.TemplateId), profile.IsTemplate)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 			err = fmt.Errorf("profile with alias '%s' already exists", profile.Alias)
+			if aliasIsInTrash(tx, profile.Alias) {
+				err = fmt.Errorf("profile with alias '%s' already exists in the trash, restore or purge it first", profile.Alias)
+			}
 		}
 		return 0, err
 	}
 
-	var id int64
-	if id, err = res.LastInsertId(); err != nil {
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err = setProfileTags(tx, id, profile.Tags); err != nil {
 		return 0, err
 	}
-	return id, nil
+	return id, setProfileEnv(tx, id, profile.Env)
 }
 
 func (d *DB) GetSSHProfileById(id int64) (SSHProfile, error) {
 	var profile SSHProfile
 
-	row := d.db.QueryRow("SELECT id, alias, host, user, password, privateKey, startupCommand, type, encrypted, ctime, mtime FROM SSH_Profile WHERE id=?;", id)
-	if err := row.Scan(&profile.Id, &profile.Alias, &profile.Host, &profile.User, &profile.Password, &profile.PrivateKey, &profile.StartupCommand, &profile.AuthType, &profile.Encrypted, &profile.CTime, &profile.MTime); err == sql.ErrNoRows {
+	row := d.db.QueryRow("SELECT "+profileColumns+" FROM SSH_Profile WHERE id=? AND deletedAt IS NULL;", id)
+	if err := scanProfile(row, &profile); err == sql.ErrNoRows {
 		return SSHProfile{}, err
 	}
+
+	var err error
+	if profile.Tags, err = d.getProfileTags(profile.Id); err != nil {
+		return profile, err
+	}
+	if profile.Env, err = d.getProfileEnv(profile.Id); err != nil {
+		return profile, err
+	}
 	return profile, nil
 }
 
 func (d *DB) GetSSHProfileByAlias(alias string) (SSHProfile, error) {
 	var profile SSHProfile
 
-	row := d.db.QueryRow("SELECT id, alias, host, user, password, privateKey, startupCommand, type, encrypted, ctime, mtime FROM SSH_Profile WHERE alias=?;", alias)
-	if err := row.Scan(&profile.Id, &profile.Alias, &profile.Host, &profile.User, &profile.Password, &profile.PrivateKey, &profile.StartupCommand, &profile.AuthType, &profile.Encrypted, &profile.CTime, &profile.MTime); err == sql.ErrNoRows {
+	row := d.db.QueryRow("SELECT "+profileColumns+" FROM SSH_Profile WHERE alias=? AND deletedAt IS NULL;", alias)
+	if err := scanProfile(row, &profile); err == sql.ErrNoRows {
 		return SSHProfile{}, err
 	}
+
+	var err error
+	if profile.Tags, err = d.getProfileTags(profile.Id); err != nil {
+		return profile, err
+	}
+	if profile.Env, err = d.getProfileEnv(profile.Id); err != nil {
+		return profile, err
+	}
 	return profile, nil
 }
 
 func (d *DB) GetSSHProfilesById(ids []int64) ([]SSHProfile, error) {
 	var profiles []SSHProfile
 
-	query := "SELECT id, alias, host, user, password, privateKey, startupCommand, type, encrypted, ctime, mtime FROM SSH_Profile WHERE"
+	if len(ids) == 0 {
+		return profiles, nil
+	}
+
+	query := "SELECT " + profileColumns + " FROM SSH_Profile WHERE deletedAt IS NULL AND ("
 	for i, id := range ids {
 		if i == 0 {
 			query = fmt.Sprintf("%s id=%d", query, id)
@@ -54,6 +128,7 @@ func (d *DB) GetSSHProfilesById(ids []int64) ([]SSHProfile, error) {
 		}
 		query = fmt.Sprintf("%s OR id=%d", query, id)
 	}
+	query += ");"
 
 	rows, err := d.db.Query(query)
 	if err != nil {
@@ -63,18 +138,25 @@ func (d *DB) GetSSHProfilesById(ids []int64) ([]SSHProfile, error) {
 
 	for rows.Next() {
 		var profile SSHProfile
-		if err = rows.Scan(&profile.Id, &profile.Alias, &profile.Host, &profile.User, &profile.Password, &profile.PrivateKey, &profile.StartupCommand, &profile.AuthType, &profile.Encrypted, &profile.CTime, &profile.MTime); err == sql.ErrNoRows {
+		if err = scanProfile(rows, &profile); err == sql.ErrNoRows {
 			return profiles, err
 		}
 		profiles = append(profiles, profile)
 	}
+
+	if err = d.attachTags(profiles); err != nil {
+		return profiles, err
+	}
+	if err = d.attachEnv(profiles); err != nil {
+		return profiles, err
+	}
 	return profiles, nil
 }
 
 func (d *DB) GetAllSSHProfiles() ([]SSHProfile, error) {
 	var profiles []SSHProfile
 
-	rows, err := d.db.Query("SELECT id, alias, host, user, password, privateKey, startupCommand, type, encrypted, ctime, mtime FROM SSH_Profile;")
+	rows, err := d.db.Query("SELECT " + profileColumns + " FROM SSH_Profile WHERE deletedAt IS NULL;")
 	if err != nil {
 		return profiles, err
 	}
@@ -82,7 +164,7 @@ func (d *DB) GetAllSSHProfiles() ([]SSHProfile, error) {
 
 	for rows.Next() {
 		var profile SSHProfile
-		if err = rows.Scan(&profile.Id, &profile.Alias, &profile.Host, &profile.User, &profile.Password, &profile.PrivateKey, &profile.StartupCommand, &profile.AuthType, &profile.Encrypted, &profile.CTime, &profile.MTime); err == sql.ErrNoRows {
+		if err = scanProfile(rows, &profile); err == sql.ErrNoRows {
 			return profiles, err
 		}
 		profiles = append(profiles, profile)
@@ -91,38 +173,180 @@ func (d *DB) GetAllSSHProfiles() ([]SSHProfile, error) {
 	if err = rows.Err(); err != nil {
 		return profiles, err
 	}
+
+	if err = d.attachTags(profiles); err != nil {
+		return profiles, err
+	}
+	if err = d.attachEnv(profiles); err != nil {
+		return profiles, err
+	}
 	return profiles, nil
 }
 
+// GetSSHProfileSummaries returns all profiles without loading their secrets.
+func (d *DB) GetSSHProfileSummaries() ([]SSHProfileSummary, error) {
+	var summaries []SSHProfileSummary
+
+	rows, err := d.db.Query("SELECT " + summaryColumns + " FROM SSH_Profile WHERE deletedAt IS NULL;")
+	if err != nil {
+		return summaries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary SSHProfileSummary
+		if err = scanSummary(rows, &summary); err != nil {
+			return summaries, err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if err = rows.Err(); err != nil {
+		return summaries, err
+	}
+
+	if err = d.attachSummaryTags(summaries); err != nil {
+		return summaries, err
+	}
+	return summaries, nil
+}
+
 func (d *DB) UpdateSSHProfileById(id int64, updatedProfile SSHProfile) error {
 	var auth string
 	var query string
 
 	if updatedProfile.AuthType == AuthTypePrivateKey {
 		auth = string(updatedProfile.PrivateKey)
-		query = "UPDATE SSH_Profile SET alias=?, host=?, user=?, privateKey=?, startupCommand=?, type=?, encrypted=?, mtime=? WHERE id=?;"
+		query = "UPDATE SSH_Profile SET alias=?, host=?, port=?, user=?, privateKey=?, startupCommand=?, type=?, encrypted=?, keyCheck=?, secretRef=?, agentKey=?, templateId=?, mtime=? WHERE id=? AND deletedAt IS NULL;"
 	} else {
 		auth = updatedProfile.Password
-		query = "UPDATE SSH_Profile SET alias=?, host=?, user=?, password=?, startupCommand=?, type=?, encrypted=?, mtime=? WHERE id=?;"
+		query = "UPDATE SSH_Profile SET alias=?, host=?, port=?, user=?, password=?, startupCommand=?, type=?, encrypted=?, keyCheck=?, secretRef=?, agentKey=?, templateId=?, mtime=? WHERE id=? AND deletedAt IS NULL;"
 	}
 	mtime := time.Now().Format("2006-01-02 15:04:05")
 
-	if _, err := d.db.Exec(query, updatedProfile.Alias, updatedProfile.Host, updatedProfile.User, auth, updatedProfile.StartupCommand, updatedProfile.AuthType, updatedProfile.Encrypted, mtime, id); err != nil {
+	return d.withTx(func(tx *sql.Tx) error {
+		// Keep the current version around, so the update can be rolled back
+		if err := recordRevision(tx, id); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(query, updatedProfile.Alias, updatedProfile.Host, updatedProfile.Port, updatedProfile.User, auth, updatedProfile.StartupCommand, updatedProfile.AuthType, updatedProfile.Encrypted, updatedProfile.KeyCheck, updatedProfile.SecretRef, updatedProfile.AgentKey, nullableId(updatedProfile.TemplateId), mtime, id); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				err = fmt.Errorf("profile with alias '%s' already exists", updatedProfile.Alias)
+			}
+			return err
+		}
+		if err := setProfileTags(tx, id, updatedProfile.Tags); err != nil {
+			return err
+		}
+		return setProfileEnv(tx, id, updatedProfile.Env)
+	})
+}
+
+// DeleteSSHProfileById moves the profile into the trash, use PurgeDeletedSSHProfiles to remove it for good.
+func (d *DB) DeleteSSHProfileById(id int64) error {
+	return deleteProfile(d.db, id)
+}
+
+// DeleteSSHProfilesById moves all profiles into the trash in a single transaction, if one of them fails none is deleted.
+func (d *DB) DeleteSSHProfilesById(ids []int64) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := deleteProfile(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func deleteProfile(q querier, id int64) error {
+	var res sql.Result
+	var children int
+	var err error
+
+	// Profiles in the trash count as well, they would lose their settings once the template is purged
+	if err = q.QueryRow("SELECT COUNT(*) FROM SSH_Profile WHERE templateId=?;", id).Scan(&children); err != nil {
+		return err
+	}
+	if children > 0 {
+		return fmt.Errorf("profile with id '%d' is a template used by %d profile(s), delete or purge them first", id, children)
+	}
+
+	if res, err = q.Exec("UPDATE SSH_Profile SET deletedAt=? WHERE id=? AND deletedAt IS NULL;", time.Now().UTC(), id); err != nil {
 		return err
 	}
+
+	if updates, _ := res.RowsAffected(); updates == 0 {
+		return fmt.Errorf("are you sure a profile with id '%d' exists?", id)
+	}
 	return nil
 }
 
-func (d *DB) DeleteSSHProfileById(id int64) error {
+// GetDeletedSSHProfiles returns all profiles in the trash, most recently deleted first.
+func (d *DB) GetDeletedSSHProfiles() ([]SSHProfileSummary, error) {
+	var summaries []SSHProfileSummary
+
+	rows, err := d.db.Query("SELECT " + summaryColumns + ", deletedAt FROM SSH_Profile WHERE deletedAt IS NOT NULL ORDER BY deletedAt DESC;")
+	if err != nil {
+		return summaries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary SSHProfileSummary
+		if err = scanSummary(rows, &summary, &summary.DeletedAt); err != nil {
+			return summaries, err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if err = rows.Err(); err != nil {
+		return summaries, err
+	}
+
+	if err = d.attachSummaryTags(summaries); err != nil {
+		return summaries, err
+	}
+	return summaries, nil
+}
+
+func (d *DB) RestoreSSHProfileById(id int64) error {
 	var res sql.Result
 	var err error
 
-	if res, err = d.db.Exec("DELETE FROM SSH_Profile WHERE id=?", id); err != nil {
+	if res, err = d.db.Exec("UPDATE SSH_Profile SET deletedAt=NULL WHERE id=? AND deletedAt IS NOT NULL;", id); err != nil {
 		return err
 	}
 
 	if updates, _ := res.RowsAffected(); updates == 0 {
-		return fmt.Errorf("are you sure a profile with id '%d' exists?", id)
+		return fmt.Errorf("are you sure a profile with id '%d' is in the trash?", id)
 	}
 	return nil
 }
+
+// PurgeDeletedSSHProfiles permanently removes all profiles that were moved to the trash before the provided time.
+func (d *DB) PurgeDeletedSSHProfiles(deletedBefore time.Time) (int64, error) {
+	res, err := d.db.Exec("DELETE FROM SSH_Profile WHERE deletedAt IS NOT NULL AND deletedAt<=?;", deletedBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// nullableId stores an id of 0 as NULL, so it doesn't violate the foreign key.
+func nullableId(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func aliasIsInTrash(q querier, alias string) bool {
+	var count int
+
+	if err := q.QueryRow("SELECT COUNT(*) FROM SSH_Profile WHERE alias=? AND deletedAt IS NOT NULL;", alias).Scan(&count); err != nil {
+		return false
+	}
+	return count > 0
+}
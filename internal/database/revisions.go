@@ -0,0 +1,155 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	QueryCreateRevisionTable = `
+  CREATE TABLE IF NOT EXISTS SSH_Profile_Revision (
+    id INTEGER NOT NULL PRIMARY KEY,
+    profileId INTEGER NOT NULL REFERENCES SSH_Profile(id) ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    alias TEXT NOT NULL,
+    host TEXT NOT NULL,
+    port INTEGER NOT NULL,
+    user TEXT NOT NULL,
+    password TEXT,
+    privateKey BLOB,
+    startupCommand TEXT,
+    type TINYINT NOT NULL,
+    encrypted BOOLEAN NOT NULL DEFAULT 0,
+    tags TEXT NOT NULL DEFAULT '',
+    ctime DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (profileId, revision)
+  );`
+)
+
+// A previous version of a profile, secrets are stored exactly like they were stored in the profile
+type ProfileRevision struct {
+	Revision int
+	Profile  SSHProfile
+	CTime    time.Time // when the profile got replaced by a newer version
+}
+
+// GetProfileRevisions returns all revisions of a profile, newest first.
+func (d *DB) GetProfileRevisions(profileId int64) ([]ProfileRevision, error) {
+	var revisions []ProfileRevision
+
+	rows, err := d.db.Query("SELECT revision, alias, host, port, user, password, privateKey, COALESCE(startupCommand, ''), type, encrypted, keyCheck, secretRef, agentKey, COALESCE(templateId, 0), tags, env, ctime FROM SSH_Profile_Revision WHERE profileId=? ORDER BY revision DESC;", profileId)
+	if err != nil {
+		return revisions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision ProfileRevision
+		var tags, env string
+		p := &revision.Profile
+		if err = rows.Scan(&revision.Revision, &p.Alias, &p.Host, &p.Port, &p.User, &p.Password, &p.PrivateKey, &p.StartupCommand, &p.AuthType, &p.Encrypted, &p.KeyCheck, &p.SecretRef, &p.AgentKey, &p.TemplateId, &tags, &env, &revision.CTime); err != nil {
+			return revisions, err
+		}
+		p.Id = profileId
+		p.Tags = splitTags(tags)
+		if p.Env, err = decodeEnv(env); err != nil {
+			return revisions, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// RollbackSSHProfile restores a profile to the provided revision.
+// The current version is recorded as a new revision, so a rollback can be undone as well.
+func (d *DB) RollbackSSHProfile(profileId int64, revision int) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		var p SSHProfile
+		var tags, env string
+
+		row := tx.QueryRow("SELECT alias, host, port, user, password, privateKey, COALESCE(startupCommand, ''), type, encrypted, keyCheck, secretRef, agentKey, COALESCE(templateId, 0), tags, env FROM SSH_Profile_Revision WHERE profileId=? AND revision=?;", profileId, revision)
+		if err := row.Scan(&p.Alias, &p.Host, &p.Port, &p.User, &p.Password, &p.PrivateKey, &p.StartupCommand, &p.AuthType, &p.Encrypted, &p.KeyCheck, &p.SecretRef, &p.AgentKey, &p.TemplateId, &tags, &env); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("revision %d does not exist", revision)
+			}
+			return err
+		}
+		envVars, err := decodeEnv(env)
+		if err != nil {
+			return err
+		}
+		if p.TemplateId != 0 {
+			var trashed bool
+			err = tx.QueryRow("SELECT deletedAt IS NOT NULL FROM SSH_Profile WHERE id=?;", p.TemplateId).Scan(&trashed)
+			if err == sql.ErrNoRows {
+				return revisionTemplateError(revision, false)
+			} else if err != nil {
+				return err
+			} else if trashed {
+				return revisionTemplateError(revision, true)
+			}
+		}
+
+		if err := recordRevision(tx, profileId); err != nil {
+			return err
+		}
+
+		mtime := time.Now().Format("2006-01-02 15:04:05")
+		if _, err := tx.Exec("UPDATE SSH_Profile SET alias=?, host=?, port=?, user=?, password=?, privateKey=?, startupCommand=?, type=?, encrypted=?, keyCheck=?, secretRef=?, agentKey=?, templateId=?, mtime=? WHERE id=? AND deletedAt IS NULL;", p.Alias, p.Host, p.Port, p.User, p.Password, p.PrivateKey, p.StartupCommand, p.AuthType, p.Encrypted, p.KeyCheck, p.SecretRef, p.AgentKey, nullableId(p.TemplateId), mtime, profileId); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				err = fmt.Errorf("profile with alias '%s' already exists", p.Alias)
+			}
+			return err
+		}
+		if err := setProfileTags(tx, profileId, splitTags(tags)); err != nil {
+			return err
+		}
+		return setProfileEnv(tx, profileId, envVars)
+	})
+}
+
+// revisionTemplateError explains why a revision whose template is gone can't be restored.
+// Restoring it anyway would leave the profile without the settings it inherits.
+func revisionTemplateError(revision int, trashed bool) error {
+	if trashed {
+		return fmt.Errorf("the template of revision %d is in the trash, restore it first", revision)
+	}
+	return fmt.Errorf("the template of revision %d was purged, roll back to another revision or update the profile instead", revision)
+}
+
+// recordRevision copies the current state of a profile into the revision table.
+func recordRevision(tx *sql.Tx, profileId int64) error {
+	tags, err := getTagsByProfile(tx, "WHERE pt.profileId=?", profileId)
+	if err != nil {
+		return err
+	}
+	env, err := getEnvByProfile(tx, "WHERE profileId=?", profileId)
+	if err != nil {
+		return err
+	}
+	encodedEnv, err := encodeEnv(env[profileId])
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`INSERT INTO SSH_Profile_Revision (profileId, revision, alias, host, port, user, password, privateKey, startupCommand, type, encrypted, keyCheck, secretRef, agentKey, templateId, tags, env)
+    SELECT id, (SELECT COALESCE(MAX(revision), 0) + 1 FROM SSH_Profile_Revision WHERE profileId=?), alias, host, port, user, password, privateKey, startupCommand, type, encrypted, keyCheck, secretRef, agentKey, templateId, ?, ?
+    FROM SSH_Profile WHERE id=? AND deletedAt IS NULL;`, profileId, strings.Join(tags[profileId], ","), encodedEnv, profileId)
+	if err != nil {
+		return err
+	}
+
+	if inserts, _ := res.RowsAffected(); inserts == 0 {
+		return fmt.Errorf("are you sure a profile with id '%d' exists?", profileId)
+	}
+	return nil
+}
+
+func splitTags(tags string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
@@ -1,8 +1,6 @@
 package database
 
 import (
-	"database/sql"
-
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -21,24 +19,4 @@ const (
     ctime DATETIME DEFAULT CURRENT_TIMESTAMP,
     mtime DATETIME DEFAULT CURRENT_TIMESTAMP
   );`
-
-	QueryCheckColumnExists = `
-    SELECT COUNT(*)
-    FROM pragma_table_info('SSH_Profile')
-    WHERE name='startupCommand';`
 )
-
-func (d *DB) initDatabase() error {
-	var err error
-
-	d.db, err = sql.Open("sqlite3", d.Path)
-	if err != nil {
-		return err
-	}
-
-	if _, err := d.db.Exec(QueryCreateTable); err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,18 @@
+//go:build !cgo
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// isBusy is never true, the sqlite driver can't open a database without cgo.
+func isBusy(err error) bool {
+	return false
+}
+
+// copyDatabase needs sqlite's online backup api, which isn't available without cgo.
+func copyDatabase(dest *sql.DB, src *sql.DB) error {
+	return fmt.Errorf("backups of the %s storage backend require sshman to be built with cgo", BackendSQLite)
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Available storage backends
+const (
+	BackendSQLite = "sqlite"
+	BackendJSON   = "json"
+	BackendMemory = "memory"
+)
+
+// ProfileStore covers creating, reading, updating and (soft-) deleting profiles.
+type ProfileStore interface {
+	CreateSSHProfile(profile SSHProfile) (int64, error)
+	CreateSSHProfiles(profiles []SSHProfile) ([]int64, error)
+	GetSSHProfileById(id int64) (SSHProfile, error)
+	GetSSHProfileByAlias(alias string) (SSHProfile, error)
+	GetSSHProfilesById(ids []int64) ([]SSHProfile, error)
+	GetAllSSHProfiles() ([]SSHProfile, error)
+	GetSSHProfileSummaries() ([]SSHProfileSummary, error)
+	UpdateSSHProfileById(id int64, updatedProfile SSHProfile) error
+	DeleteSSHProfileById(id int64) error
+	DeleteSSHProfilesById(ids []int64) error
+	GetDeletedSSHProfiles() ([]SSHProfileSummary, error)
+	RestoreSSHProfileById(id int64) error
+	PurgeDeletedSSHProfiles(deletedBefore time.Time) (int64, error)
+}
+
+// HistoryStore keeps track of the sessions opened with a profile.
+type HistoryStore interface {
+	CreateConnectionHistory(entry ConnectionHistory) (int64, error)
+	GetConnectionHistory(profileId int64, limit int) ([]ConnectionHistory, error)
+	GetConnectionStats() (map[int64]ConnectionStats, error)
+}
+
+// RevisionStore keeps the previous versions of updated profiles.
+type RevisionStore interface {
+	GetProfileRevisions(profileId int64) ([]ProfileRevision, error)
+	RollbackSSHProfile(profileId int64, revision int) error
+}
+
+// VaultStore keeps the settings of the master password that encrypts all profiles.
+type VaultStore interface {
+	GetVault() (*Vault, error)
+	GetEncryptedSSHProfiles() ([]SSHProfile, error)
+	GetEncryptedRevisions() ([]ProfileRevision, error)
+	SaveVault(vault *Vault, profiles []SSHProfile, revisions []ProfileRevision) error
+	UpdateSSHProfileSecrets(profiles []SSHProfile, revisions []ProfileRevision) error
+}
+
+// HostKeyStore keeps the host keys the user trusted on the first connect to a profile.
+type HostKeyStore interface {
+	GetHostKey(profileId int64) (*HostKey, error)
+	SaveHostKey(key HostKey) error
+	DeleteHostKey(profileId int64) (bool, error)
+}
+
+// Store is a complete storage backend as used by the profile service.
+type Store interface {
+	Connect() error
+	Disconnect() error
+
+	ProfileStore
+	HistoryStore
+	RevisionStore
+	VaultStore
+	HostKeyStore
+}
+
+// NewStore returns the (not yet connected) store for the provided backend.
+func NewStore(backend string, path string) (Store, error) {
+	switch backend {
+	case "", BackendSQLite:
+		return &DB{Path: path}, nil
+	case BackendJSON:
+		return NewJSONStore(path), nil
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s', use one of %s, %s or %s", backend, BackendSQLite, BackendJSON, BackendMemory)
+	}
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storeBackends creates an empty store of every backend, the sqlite and json files live in a temp dir of the test.
+var storeBackends = map[string]func(t *testing.T) Store{
+	BackendMemory: func(t *testing.T) Store {
+		return NewMemoryStore()
+	},
+	BackendJSON: func(t *testing.T) Store {
+		return NewJSONStore(filepath.Join(t.TempDir(), "sshman.json"))
+	},
+	BackendSQLite: func(t *testing.T) Store {
+		return &DB{Path: filepath.Join(t.TempDir(), "sshman.db")}
+	},
+}
+
+func createTestProfile(t *testing.T, s Store, profile SSHProfile) int64 {
+	t.Helper()
+
+	if len(profile.Host) == 0 {
+		profile.Host = "example.com"
+	}
+	if len(profile.User) == 0 {
+		profile.User = "root"
+	}
+	profile.Port = DefaultSSHPort
+	id, err := s.CreateSSHProfile(profile)
+	if err != nil {
+		t.Fatalf("could not create profile %s: %v", profile.Alias, err)
+	}
+	return id
+}
+
+func expectError(t *testing.T, err error, contains string) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("expected an error containing %q, got none", contains)
+	}
+	if !strings.Contains(err.Error(), contains) {
+		t.Fatalf("expected an error containing %q, got %q", contains, err.Error())
+	}
+}
+
+// Every backend has to enforce the same rules as the sqlite schema.
+func TestStoreContract(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, s Store)
+	}{
+		{
+			name: "alias must be unique, including the trash",
+			run: func(t *testing.T, s Store) {
+				id := createTestProfile(t, s, SSHProfile{Alias: "web"})
+				createTestProfile(t, s, SSHProfile{Alias: "db"})
+
+				_, err := s.CreateSSHProfile(SSHProfile{Alias: "db", Host: "example.com", User: "root"})
+				expectError(t, err, "already exists")
+
+				if err = s.DeleteSSHProfileById(id); err != nil {
+					t.Fatal(err)
+				}
+				_, err = s.CreateSSHProfile(SSHProfile{Alias: "web", Host: "example.com", User: "root"})
+				expectError(t, err, "already exists in the trash")
+
+				if err = s.RestoreSSHProfileById(id); err != nil {
+					t.Fatal(err)
+				}
+				if _, err = s.GetSSHProfileByAlias("web"); err != nil {
+					t.Fatalf("restored profile not found: %v", err)
+				}
+			},
+		},
+		{
+			name: "templates can't be deleted while profiles use them",
+			run: func(t *testing.T, s Store) {
+				template := createTestProfile(t, s, SSHProfile{Alias: "base", IsTemplate: true})
+				child := createTestProfile(t, s, SSHProfile{Alias: "child", TemplateId: template})
+
+				expectError(t, s.DeleteSSHProfileById(template), "template used by 1 profile(s)")
+
+				// A child in the trash still needs its template
+				if err := s.DeleteSSHProfileById(child); err != nil {
+					t.Fatal(err)
+				}
+				expectError(t, s.DeleteSSHProfilesById([]int64{template}), "template used by 1 profile(s)")
+
+				if _, err := s.PurgeDeletedSSHProfiles(time.Now().Add(time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.DeleteSSHProfileById(template); err != nil {
+					t.Fatalf("template without profiles could not be deleted: %v", err)
+				}
+			},
+		},
+		{
+			name: "revisions are numbered per profile and rollbacks are recorded",
+			run: func(t *testing.T, s Store) {
+				id := createTestProfile(t, s, SSHProfile{Alias: "web", Host: "one"})
+				other := createTestProfile(t, s, SSHProfile{Alias: "other"})
+
+				for _, host := range []string{"two", "three"} {
+					profile, err := s.GetSSHProfileById(id)
+					if err != nil {
+						t.Fatal(err)
+					}
+					profile.Host = host
+					if err = s.UpdateSSHProfileById(id, profile); err != nil {
+						t.Fatal(err)
+					}
+				}
+				otherProfile, _ := s.GetSSHProfileById(other)
+				if err := s.UpdateSSHProfileById(other, otherProfile); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := s.RollbackSSHProfile(id, 1); err != nil {
+					t.Fatal(err)
+				}
+				expectError(t, s.RollbackSSHProfile(id, 9), "revision 9 does not exist")
+
+				profile, err := s.GetSSHProfileById(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if profile.Host != "one" {
+					t.Fatalf("rollback restored host %q, expected %q", profile.Host, "one")
+				}
+
+				revisions, err := s.GetProfileRevisions(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, r := range revisions {
+					got = append(got, r.Profile.Host)
+					if r.Revision != len(revisions)-len(got)+1 {
+						t.Fatalf("revisions %v aren't numbered newest first", revisions)
+					}
+				}
+				if strings.Join(got, ",") != "three,two,one" {
+					t.Fatalf("revisions hold the hosts %v, expected three,two,one", got)
+				}
+
+				if revisions, _ = s.GetProfileRevisions(other); len(revisions) != 1 || revisions[0].Revision != 1 {
+					t.Fatalf("revisions of another profile are numbered separately, got %v", revisions)
+				}
+			},
+		},
+		{
+			name: "rollbacks to a revision whose template is gone are rejected",
+			run: func(t *testing.T, s Store) {
+				template := createTestProfile(t, s, SSHProfile{Alias: "base", IsTemplate: true})
+				child := createTestProfile(t, s, SSHProfile{Alias: "child", TemplateId: template})
+
+				profile, _ := s.GetSSHProfileById(child)
+				profile.TemplateId = 0
+				if err := s.UpdateSSHProfileById(child, profile); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := s.DeleteSSHProfileById(template); err != nil {
+					t.Fatal(err)
+				}
+				expectError(t, s.RollbackSSHProfile(child, 1), "the template of revision 1 is in the trash")
+
+				if _, err := s.PurgeDeletedSSHProfiles(time.Now().Add(time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+				expectError(t, s.RollbackSSHProfile(child, 1), "the template of revision 1 was purged")
+
+				if profile, _ = s.GetSSHProfileById(child); profile.TemplateId != 0 {
+					t.Fatalf("the rejected rollback set the template to %d", profile.TemplateId)
+				}
+				if revisions, _ := s.GetProfileRevisions(child); len(revisions) != 1 {
+					t.Fatalf("the rejected rollbacks recorded revisions: %v", revisions)
+				}
+			},
+		},
+		{
+			name: "purging a profile removes everything that belongs to it",
+			run: func(t *testing.T, s Store) {
+				id := createTestProfile(t, s, SSHProfile{Alias: "web", Tags: []string{"prod"}, Env: map[string]string{"LANG": "C"}})
+				kept := createTestProfile(t, s, SSHProfile{Alias: "kept"})
+
+				profile, _ := s.GetSSHProfileById(id)
+				if err := s.UpdateSSHProfileById(id, profile); err != nil {
+					t.Fatal(err)
+				}
+				for _, profileId := range []int64{id, kept} {
+					if _, err := s.CreateConnectionHistory(ConnectionHistory{ProfileId: profileId, Start: time.Now(), End: time.Now()}); err != nil {
+						t.Fatal(err)
+					}
+					if err := s.SaveHostKey(HostKey{ProfileId: profileId, Address: "example.com:22", KeyType: "ssh-ed25519", Fingerprint: "SHA256:test"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if err := s.DeleteSSHProfileById(id); err != nil {
+					t.Fatal(err)
+				}
+				purged, err := s.PurgeDeletedSSHProfiles(time.Now().Add(time.Minute))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if purged != 1 {
+					t.Fatalf("purged %d profiles, expected 1", purged)
+				}
+
+				if history, _ := s.GetConnectionHistory(0, 10); len(history) != 1 || history[0].ProfileId != kept {
+					t.Fatalf("history of the purged profile was kept: %v", history)
+				}
+				if revisions, _ := s.GetProfileRevisions(id); len(revisions) != 0 {
+					t.Fatalf("revisions of the purged profile were kept: %v", revisions)
+				}
+				if key, _ := s.GetHostKey(id); key != nil {
+					t.Fatalf("host key of the purged profile was kept: %v", key)
+				}
+				if key, _ := s.GetHostKey(kept); key == nil {
+					t.Fatal("host key of another profile was removed")
+				}
+				if deleted, _ := s.GetDeletedSSHProfiles(); len(deleted) != 0 {
+					t.Fatalf("purged profile is still in the trash: %v", deleted)
+				}
+			},
+		},
+		{
+			name: "returned secrets don't share memory with the store",
+			run: func(t *testing.T, s Store) {
+				key := []byte("private key")
+				id := createTestProfile(t, s, SSHProfile{Alias: "web", AuthType: AuthTypePrivateKey, PrivateKey: key})
+				clear(key)
+
+				profile, err := s.GetSSHProfileById(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = s.UpdateSSHProfileById(id, profile); err != nil {
+					t.Fatal(err)
+				}
+				clear(profile.PrivateKey)
+				revisions, _ := s.GetProfileRevisions(id)
+				clear(revisions[0].Profile.PrivateKey)
+
+				if profile, _ = s.GetSSHProfileById(id); string(profile.PrivateKey) != "private key" {
+					t.Fatalf("wiping a returned key changed the stored one to %q", profile.PrivateKey)
+				}
+				if revisions, _ = s.GetProfileRevisions(id); string(revisions[0].Profile.PrivateKey) != "private key" {
+					t.Fatalf("wiping a returned key changed the revision to %q", revisions[0].Profile.PrivateKey)
+				}
+			},
+		},
+		{
+			name: "saving the vault replaces the revision secrets and drops the ones left out",
+			run: func(t *testing.T, s Store) {
+				id := createTestProfile(t, s, SSHProfile{Alias: "web", AuthType: AuthTypePassword, Password: "a", Encrypted: true})
+				plain := createTestProfile(t, s, SSHProfile{Alias: "plain", AuthType: AuthTypePassword, Password: "p"})
+
+				for _, password := range []string{"b", "c"} {
+					profile, _ := s.GetSSHProfileById(id)
+					profile.Password = password
+					if err := s.UpdateSSHProfileById(id, profile); err != nil {
+						t.Fatal(err)
+					}
+				}
+				plainProfile, _ := s.GetSSHProfileById(plain)
+				if err := s.UpdateSSHProfileById(plain, plainProfile); err != nil {
+					t.Fatal(err)
+				}
+
+				revisions, err := s.GetEncryptedRevisions()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(revisions) != 2 {
+					t.Fatalf("got %d encrypted revisions, expected 2", len(revisions))
+				}
+				var kept []ProfileRevision
+				for _, r := range revisions {
+					if r.Revision == 1 {
+						r.Profile.Password, r.Profile.KeyCheck = "A", "check"
+						kept = append(kept, r)
+					}
+				}
+				if err = s.SaveVault(&Vault{KDF: "argon2id", Salt: []byte("salt"), Check: "check"}, nil, kept); err != nil {
+					t.Fatal(err)
+				}
+
+				revisions, _ = s.GetProfileRevisions(id)
+				if len(revisions) != 1 || revisions[0].Revision != 1 {
+					t.Fatalf("the revision left out wasn't deleted: %v", revisions)
+				}
+				if revisions[0].Profile.Password != "A" || revisions[0].Profile.KeyCheck != "check" {
+					t.Fatalf("the secret of the revision wasn't replaced: %v", revisions[0].Profile)
+				}
+				if revisions, _ = s.GetProfileRevisions(plain); len(revisions) != 1 {
+					t.Fatalf("revisions without an encrypted secret were changed: %v", revisions)
+				}
+			},
+		},
+	}
+
+	for backend, newStore := range storeBackends {
+		for _, c := range cases {
+			t.Run(backend+"/"+c.name, func(t *testing.T) {
+				s := newStore(t)
+				if err := s.Connect(); err != nil {
+					t.Fatalf("could not connect: %v", err)
+				}
+				defer s.Disconnect()
+
+				c.run(t, s)
+			})
+		}
+	}
+}
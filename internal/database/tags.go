@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+)
+
+const (
+	QueryCreateTagTables = `
+  CREATE TABLE IF NOT EXISTS Tag (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+  );
+  CREATE TABLE IF NOT EXISTS SSH_Profile_Tag (
+    profileId INTEGER NOT NULL REFERENCES SSH_Profile(id) ON DELETE CASCADE,
+    tagId INTEGER NOT NULL REFERENCES Tag(id) ON DELETE CASCADE,
+    PRIMARY KEY (profileId, tagId)
+  );`
+)
+
+// GetAllTags returns the names of all tags that are assigned to at least one profile.
+func (d *DB) GetAllTags() ([]string, error) {
+	var tags []string
+
+	rows, err := d.db.Query("SELECT DISTINCT t.name FROM Tag t JOIN SSH_Profile_Tag pt ON pt.tagId=t.id ORDER BY t.name;")
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		if err = rows.Scan(&tag); err != nil {
+			return tags, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// getProfileTags returns the tags of a single profile.
+func (d *DB) getProfileTags(profileId int64) ([]string, error) {
+	tags, err := getTagsByProfile(d.db, "WHERE pt.profileId=?", profileId)
+	if err != nil {
+		return nil, err
+	}
+	return tags[profileId], nil
+}
+
+// getTagsByProfile returns the tags of all profiles matching the filter, mapped by profile id.
+func getTagsByProfile(q querier, filter string, args ...any) (map[int64][]string, error) {
+	tags := make(map[int64][]string)
+
+	rows, err := q.Query("SELECT pt.profileId, t.name FROM SSH_Profile_Tag pt JOIN Tag t ON t.id=pt.tagId "+filter+" ORDER BY t.name;", args...)
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profileId int64
+		var tag string
+		if err = rows.Scan(&profileId, &tag); err != nil {
+			return tags, err
+		}
+		tags[profileId] = append(tags[profileId], tag)
+	}
+	return tags, rows.Err()
+}
+
+// attachTags loads the tags for every profile in the slice.
+func (d *DB) attachTags(profiles []SSHProfile) error {
+	tags, err := getTagsByProfile(d.db, "")
+	if err != nil {
+		return err
+	}
+	for i := range profiles {
+		profiles[i].Tags = tags[profiles[i].Id]
+	}
+	return nil
+}
+
+// attachSummaryTags loads the tags for every summary in the slice.
+func (d *DB) attachSummaryTags(summaries []SSHProfileSummary) error {
+	tags, err := getTagsByProfile(d.db, "")
+	if err != nil {
+		return err
+	}
+	for i := range summaries {
+		summaries[i].Tags = tags[summaries[i].Id]
+	}
+	return nil
+}
+
+// setProfileTags replaces the tags of a profile, tags that aren't used anymore get removed.
+func setProfileTags(tx *sql.Tx, profileId int64, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM SSH_Profile_Tag WHERE profileId=?;", profileId); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO Tag (name) VALUES(?);", tag); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO SSH_Profile_Tag (profileId, tagId) SELECT ?, id FROM Tag WHERE name=?;", profileId, tag); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec("DELETE FROM Tag WHERE id NOT IN (SELECT tagId FROM SSH_Profile_Tag);")
+	return err
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	QueryCreateVaultTable = `
+  CREATE TABLE IF NOT EXISTS Vault (
+    id INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+    kdf TEXT NOT NULL,
+    salt BLOB NOT NULL,
+    iterations INTEGER NOT NULL,
+    memory INTEGER NOT NULL,
+    threads INTEGER NOT NULL,
+    checkValue TEXT NOT NULL,
+    ctime DATETIME DEFAULT CURRENT_TIMESTAMP
+  );`
+)
+
+// Vault holds everything needed to derive the key of all encrypted profiles from the master password.
+// It never contains the password or the derived key.
+type Vault struct {
+	KDF        string // see helpers.KDFArgon2id
+	Salt       []byte
+	Iterations uint32
+	Memory     uint32 // in KiB
+	Threads    uint8
+	Check      string // a known value encrypted with the derived key, used to verify the master password
+	CTime      time.Time
+}
+
+// GetVault returns the vault settings, nil if vault mode isn't enabled.
+func (d *DB) GetVault() (*Vault, error) {
+	var vault Vault
+
+	row := d.db.QueryRow("SELECT kdf, salt, iterations, memory, threads, checkValue, ctime FROM Vault WHERE id=1;")
+	if err := row.Scan(&vault.KDF, &vault.Salt, &vault.Iterations, &vault.Memory, &vault.Threads, &vault.Check, &vault.CTime); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &vault, nil
+}
+
+// GetEncryptedSSHProfiles returns every profile with an encrypted secret, including the ones in the trash.
+// Tags and environment variables aren't loaded.
+func (d *DB) GetEncryptedSSHProfiles() ([]SSHProfile, error) {
+	var profiles []SSHProfile
+
+	rows, err := d.db.Query("SELECT " + profileColumns + " FROM SSH_Profile WHERE encrypted AND COALESCE(length(password), 0) + COALESCE(length(privateKey), 0) > 0;")
+	if err != nil {
+		return profiles, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profile SSHProfile
+		if err = scanProfile(rows, &profile); err != nil {
+			return profiles, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+// GetEncryptedRevisions returns every revision with an encrypted secret, including the ones of profiles in the trash.
+// Only the alias and the fields needed to re-encrypt the secret are loaded.
+func (d *DB) GetEncryptedRevisions() ([]ProfileRevision, error) {
+	var revisions []ProfileRevision
+
+	rows, err := d.db.Query("SELECT profileId, revision, alias, password, privateKey, type, encrypted, keyCheck FROM SSH_Profile_Revision WHERE encrypted AND COALESCE(length(password), 0) + COALESCE(length(privateKey), 0) > 0;")
+	if err != nil {
+		return revisions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision ProfileRevision
+		p := &revision.Profile
+		if err = rows.Scan(&p.Id, &revision.Revision, &p.Alias, &p.Password, &p.PrivateKey, &p.AuthType, &p.Encrypted, &p.KeyCheck); err != nil {
+			return revisions, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// SaveVault stores the vault settings together with the re-encrypted secrets of the profiles and revisions in a single transaction,
+// so the stored secrets always match the vault. A nil vault disables vault mode.
+// Encrypted revisions missing from revisions couldn't be re-encrypted, they are deleted so no secret of the old key is left behind.
+func (d *DB) SaveVault(vault *Vault, profiles []SSHProfile, revisions []ProfileRevision) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM Vault;"); err != nil {
+			return err
+		}
+		if vault != nil {
+			if _, err := tx.Exec("INSERT INTO Vault (id, kdf, salt, iterations, memory, threads, checkValue) VALUES(1, ?, ?, ?, ?, ?, ?);", vault.KDF, vault.Salt, vault.Iterations, vault.Memory, vault.Threads, vault.Check); err != nil {
+				return err
+			}
+		}
+		if err := updateSecrets(tx, profiles); err != nil {
+			return err
+		}
+		if err := updateRevisionSecrets(tx, revisions); err != nil {
+			return err
+		}
+		return deleteEncryptedRevisions(tx, revisions)
+	})
+}
+
+// UpdateSSHProfileSecrets stores the secrets of the profiles and revisions without creating a revision, the profiles themselves don't change.
+func (d *DB) UpdateSSHProfileSecrets(profiles []SSHProfile, revisions []ProfileRevision) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		if err := updateSecrets(tx, profiles); err != nil {
+			return err
+		}
+		return updateRevisionSecrets(tx, revisions)
+	})
+}
+
+func updateSecrets(tx *sql.Tx, profiles []SSHProfile) error {
+	for _, profile := range profiles {
+		res, err := tx.Exec("UPDATE SSH_Profile SET password=?, privateKey=?, encrypted=?, keyCheck=? WHERE id=?;", profile.Password, profile.PrivateKey, profile.Encrypted, profile.KeyCheck, profile.Id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("are you sure a profile with id '%d' exists?", profile.Id)
+		}
+	}
+	return nil
+}
+
+func updateRevisionSecrets(tx *sql.Tx, revisions []ProfileRevision) error {
+	for _, revision := range revisions {
+		p := revision.Profile
+		res, err := tx.Exec("UPDATE SSH_Profile_Revision SET password=?, privateKey=?, encrypted=?, keyCheck=? WHERE profileId=? AND revision=?;", p.Password, p.PrivateKey, p.Encrypted, p.KeyCheck, p.Id, revision.Revision)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("revision %d of the profile with id '%d' doesn't exist", revision.Revision, p.Id)
+		}
+	}
+	return nil
+}
+
+// deleteEncryptedRevisions deletes every revision with an encrypted secret that isn't one of kept.
+func deleteEncryptedRevisions(tx *sql.Tx, kept []ProfileRevision) error {
+	type revisionKey struct {
+		profileId int64
+		revision  int
+	}
+
+	keep := make(map[revisionKey]bool)
+	for _, revision := range kept {
+		keep[revisionKey{revision.Profile.Id, revision.Revision}] = true
+	}
+
+	rows, err := tx.Query("SELECT profileId, revision FROM SSH_Profile_Revision WHERE encrypted AND COALESCE(length(password), 0) + COALESCE(length(privateKey), 0) > 0;")
+	if err != nil {
+		return err
+	}
+	var dropped []revisionKey
+	for rows.Next() {
+		var key revisionKey
+		if err = rows.Scan(&key.profileId, &key.revision); err != nil {
+			rows.Close()
+			return err
+		}
+		if !keep[key] {
+			dropped = append(dropped, key)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range dropped {
+		if _, err = tx.Exec("DELETE FROM SSH_Profile_Revision WHERE profileId=? AND revision=?;", key.profileId, key.revision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
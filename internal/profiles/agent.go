@@ -0,0 +1,34 @@
+package profiles
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mikeunge/sshman/internal/database"
+)
+
+// agentKey asks the agent for a key it remembers, a missing agent isn't an error.
+func (s *ProfileService) agentKey(name string) (string, bool) {
+	if s.Agent == nil {
+		return "", false
+	}
+	key, err := s.Agent.Get(name)
+	return key, err == nil && len(key) > 0
+}
+
+// rememberKey hands a key that worked to the agent, so the next command doesn't have to ask for it.
+// The agent is only a cache, so it's fine if there is none.
+func (s *ProfileService) rememberKey(name string, key string) {
+	if s.Agent != nil {
+		s.Agent.Set(name, key)
+	}
+}
+
+// The vault key is stored under its salt, so the agent can't hand out a key of a previous master password
+func vaultKeyName(vault database.Vault) string {
+	return "vault:" + hex.EncodeToString(vault.Salt)
+}
+
+func profileKeyName(profile database.SSHProfile) string {
+	return fmt.Sprintf("profile:%d", profile.Id)
+}
@@ -0,0 +1,204 @@
+package profiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/pkg/helpers"
+	"github.com/mikeunge/sshman/pkg/logger"
+
+	"github.com/pterm/pterm"
+)
+
+// First line of every bundle, followed by the encrypted profiles
+const bundleHeader = "sshman-bundle v1\n"
+
+type bundle struct {
+	Profiles []database.SSHProfile `json:"profiles"`
+}
+
+func isBundle(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bundleHeader))
+}
+
+// ExportBundle writes the selected profiles into a single file encrypted with a bundle passphrase.
+// Every secret is re-encrypted with the bundle key as well, so it's never written in clear.
+func (s *ProfileService) ExportBundle(p string) error {
+	startTime := time.Now()
+	sessionID := fmt.Sprintf("export_%d", startTime.Unix())
+
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, "Starting bundle export", "export", sessionID)
+	}
+
+	profiles, err := s.profilesForExport(p, sessionID)
+	if err != nil {
+		return err
+	}
+
+	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Exporting %d profile(s), the bundle passphrase is needed to import them\n", len(profiles))
+	password, err := s.askNewPassword("Bundle passphrase")
+	if err != nil {
+		return err
+	}
+	content, err := s.sealBundle(profiles, password, sessionID)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%d.sshman", time.Now().Unix())
+	if err = os.WriteFile(path, content, 0600); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to write bundle", "export", sessionID, err)
+		}
+		return fmt.Errorf("could not write the bundle, %s", err.Error())
+	}
+
+	if s.Logger != nil {
+		s.Logger.LogWithDetails(logger.INFO, fmt.Sprintf("Successfully exported %d profiles to bundle %s", len(profiles), path), "export", sessionID, time.Since(startTime).String(), startTime, time.Now(), nil)
+	}
+
+	fmt.Println()
+	pterm.Success.Printf("Bundle created: %s\n", path)
+	return nil
+}
+
+// readBundle decrypts a bundle with its passphrase, secrets of encrypted profiles are re-encrypted with the local key.
+func (s *ProfileService) readBundle(data []byte) ([]database.SSHProfile, error) {
+	kdf, err := bundleKDF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)).WithDefaultText("Bundle passphrase")
+	if s.MaskInput {
+		input.Mask = "*"
+	}
+	password, _ := input.Show()
+
+	profiles, key, err := openBundle(data, kdf, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var localKey string
+	localKDF := profileKDF
+	if slices.ContainsFunc(profiles, func(p database.SSHProfile) bool { return p.Encrypted && len(secretOf(p)) > 0 }) {
+		pterm.Info.Println("The bundle contains encrypted profiles, they are stored with your encryption key.")
+		if localKey, localKDF, err = s.newEncryptionKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range profiles {
+		profile := &profiles[i]
+		if len(secretOf(*profile)) == 0 {
+			continue
+		}
+		secret, err := decryptSecret(*profile, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt the secret of %s, %s", profile.Alias, err.Error())
+		}
+		profile.KeyCheck = ""
+		if profile.Encrypted {
+			err = encryptSecret(profile, secret, localKey, localKDF)
+		} else {
+			setSecret(profile, string(secret))
+		}
+		secret.Wipe()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// sealBundle returns the content of a bundle file with the profiles, their secrets are decrypted and sealed with the passphrase.
+func (s *ProfileService) sealBundle(profiles []database.SSHProfile, password string, sessionID string) ([]byte, error) {
+	kdf, err := s.newArgon2idKDF()
+	if err != nil {
+		return nil, err
+	}
+	key, err := helpers.DeriveKey(password, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range profiles {
+		profile := &profiles[i]
+		secret, err := s.decryptProfile(*profile, sessionID)
+		if err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError("Failed to decrypt profiles for export", "export", sessionID, err)
+			}
+			return nil, fmt.Errorf("encountered decryption error %+v", err)
+		}
+		// Encrypted keeps telling if the profile was stored encrypted, the importer stores it the same way
+		if secret != nil {
+			err = encryptSecret(profile, secret, key, kdf)
+			secret.Wipe()
+			if err != nil {
+				return nil, err
+			}
+		}
+		profile.Id, profile.TemplateId, profile.IsTemplate, profile.KeyCheck = 0, 0, false, ""
+	}
+
+	payload, err := json.Marshal(bundle{Profiles: profiles})
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := helpers.EncryptString(payload, key, kdf)
+	clear(payload)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(bundleHeader + sealed + "\n"), nil
+}
+
+// bundleKDF returns the key derivation of a bundle, it's checked before the passphrase is asked for or a key derived.
+func bundleKDF(data []byte) (helpers.KDF, error) {
+	kdf, err := helpers.EnvelopeKDF(bundleEnvelope(data))
+	if err != nil {
+		return kdf, fmt.Errorf("the bundle is damaged, %s", err.Error())
+	}
+	// Bundles are always created with Argon2id, a passphrase must never be used as an unsalted hash
+	if kdf.Id != helpers.KDFArgon2id {
+		return kdf, fmt.Errorf("the bundle is damaged, unsupported key derivation function '%s'", kdf.Id)
+	}
+	if err = helpers.ValidateKDF(kdf); err != nil {
+		return kdf, fmt.Errorf("the bundle is damaged, %s", err.Error())
+	}
+	return kdf, nil
+}
+
+// openBundle decrypts the profiles of a bundle, their secrets stay sealed with the returned bundle key.
+func openBundle(data []byte, kdf helpers.KDF, password string) ([]database.SSHProfile, string, error) {
+	key, err := helpers.DeriveKey(password, kdf)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err := helpers.DecryptString(bundleEnvelope(data), key)
+	if err != nil {
+		return nil, "", fmt.Errorf("wrong bundle passphrase")
+	}
+
+	var b bundle
+	err = json.Unmarshal(payload, &b)
+	payload.Wipe()
+	if err != nil {
+		return nil, "", fmt.Errorf("the bundle is damaged, %s", err.Error())
+	}
+	return b.Profiles, key, nil
+}
+
+// bundleEnvelope returns the encrypted envelope of a bundle file.
+func bundleEnvelope(data []byte) string {
+	return strings.TrimSpace(strings.TrimPrefix(string(data), bundleHeader))
+}
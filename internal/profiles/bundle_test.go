@@ -0,0 +1,120 @@
+package profiles
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/pkg/helpers"
+)
+
+// newBundle exports a plain and an encrypted profile into a bundle sealed with password.
+func newBundle(t *testing.T, password string) []byte {
+	t.Helper()
+
+	s := newVaultService(t)
+	for _, profile := range []database.SSHProfile{
+		{Alias: "web", Host: "example.com", User: "root", Port: 22, AuthType: database.AuthTypePassword, Password: "plain"},
+		{Alias: "db", Host: "example.org", User: "root", Port: 22, AuthType: database.AuthTypePassword, Password: "encrypted"},
+	} {
+		if _, err := s.Store.CreateSSHProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.EncryptProfile("db"); err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := s.Store.GetAllSSHProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.sealBundle(profiles, password, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	data := newBundle(t, "bundle passphrase")
+	if !isBundle(data) {
+		t.Fatalf("%q is not recognized as a bundle", data)
+	}
+	for _, secret := range []string{"plain", "encrypted"} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("the bundle contains the secret %q in clear", secret)
+		}
+	}
+
+	kdf, err := bundleKDF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles, key, err := openBundle(data, kdf, "bundle passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("got %d profiles, expected 2", len(profiles))
+	}
+	for _, profile := range profiles {
+		if profile.Id != 0 || len(profile.KeyCheck) != 0 {
+			t.Fatalf("the profile %s wasn't stripped of its local id and key check", profile.Alias)
+		}
+		secret, err := decryptSecret(profile, key)
+		if err != nil {
+			t.Fatalf("the secret of %s can't be decrypted with the bundle key: %v", profile.Alias, err)
+		}
+		want := map[string]string{"web": "plain", "db": "encrypted"}[profile.Alias]
+		if string(secret) != want {
+			t.Fatalf("%s holds %q, expected %q", profile.Alias, secret, want)
+		}
+		if profile.Encrypted != (profile.Alias == "db") {
+			t.Fatalf("%s has Encrypted=%v, the bundle has to keep how it was stored", profile.Alias, profile.Encrypted)
+		}
+	}
+}
+
+func TestBundleWrongPassphrase(t *testing.T) {
+	data := newBundle(t, "bundle passphrase")
+
+	kdf, err := bundleKDF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = openBundle(data, kdf, "wrong passphrase"); err == nil || err.Error() != "wrong bundle passphrase" {
+		t.Fatalf("got %v, expected the passphrase to be rejected", err)
+	}
+}
+
+// A bundle whose header asks for an unsalted hash or an absurd amount of work is rejected before a key is derived.
+func TestBundleRejectsKeyDerivation(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"sha256", "$sshman$v=1$aes-256-gcm$sha256$$$"},
+		{"too many iterations", "$sshman$v=1$aes-256-gcm$argon2id$t=1000,m=64,p=1$73616c7473616c74$"},
+		{"too much memory", "$sshman$v=1$aes-256-gcm$argon2id$t=1,m=4194304,p=1$73616c7473616c74$"},
+		{"no threads", "$sshman$v=1$aes-256-gcm$argon2id$t=1,m=64,p=0$73616c7473616c74$"},
+		{"short salt", "$sshman$v=1$aes-256-gcm$argon2id$t=1,m=64,p=1$73616c74$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(bundleHeader + tt.header + "00\n")
+			if _, err := bundleKDF(data); err == nil || !strings.HasPrefix(err.Error(), "the bundle is damaged") {
+				t.Fatalf("got %v, expected the bundle to be rejected", err)
+			}
+		})
+	}
+
+	// The envelope itself is valid, only bundles insist on Argon2id
+	sealed, err := helpers.EncryptString([]byte(`{"profiles":[]}`), helpers.CreateHash("bundle passphrase"), helpers.KDF{Id: helpers.KDFSHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bundleKDF([]byte(bundleHeader + sealed + "\n")); err == nil || !strings.Contains(err.Error(), "unsupported key derivation function") {
+		t.Fatalf("got %v, expected a sha256 bundle to be rejected", err)
+	}
+}
@@ -1,6 +1,7 @@
 package profiles
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/mikeunge/sshman/internal/database"
@@ -10,75 +11,180 @@ import (
 	"github.com/pterm/pterm"
 )
 
-func decryptProfiles(profiles []database.SSHProfile, maskInput bool, maxTries int, logger *logger.Logger, sessionID string) error {
+var errWrongKey = errors.New("wrong encryption key")
+
+// decryptProfiles replaces the encrypted secrets of the profiles with the plain ones, exports need them in clear.
+func (s *ProfileService) decryptProfiles(profiles []database.SSHProfile, sessionID string) error {
 	for i := 0; i < len(profiles); i++ {
-		if err := decryptProfile(&profiles[i], maskInput, maxTries, logger, sessionID); err != nil {
+		secret, err := s.decryptProfile(profiles[i], sessionID)
+		if err != nil {
 			return err
 		}
+		if secret != nil {
+			setSecret(&profiles[i], string(secret))
+			secret.Wipe()
+		}
 	}
 	return nil
 }
 
-func decryptProfile(profile *database.SSHProfile, maskInput bool, maxTries int, log *logger.Logger, sessionID string) error {
-	var err error
+// decryptProfile returns the plain secret of the profile, the caller wipes it once it's used.
+// In vault mode the master password is used, otherwise the user is asked for the key of the profile.
+func (s *ProfileService) decryptProfile(profile database.SSHProfile, sessionID string) (helpers.Secret, error) {
+	secret, _, err := s.decryptProfileKey(profile, sessionID)
+	return secret, err
+}
+
+// decryptProfileKey works like decryptProfile and also returns the key that decrypted the secret,
+// it is empty if the profile doesn't store an encrypted secret.
+func (s *ProfileService) decryptProfileKey(profile database.SSHProfile, sessionID string) (helpers.Secret, string, error) {
+	log := s.Logger
+
+	// Profiles that inherit the authentication from their template don't have a secret
+	if len(secretOf(profile)) == 0 {
+		return nil, "", nil
+	}
+	if !profile.Encrypted {
+		return helpers.Secret(secretOf(profile)), "", nil
+	}
+
+	if log != nil {
+		log.Log(logger.INFO, fmt.Sprintf("Starting decryption for profile: %s", profile.Alias), "decrypt", sessionID)
+	}
+
+	legacy := helpers.IsLegacyCiphertext(secretOf(profile))
+	key, err := s.masterKey()
+	if err != nil {
+		return nil, "", err
+	}
 
-	currentTry := 0
-	if profile.Encrypted {
+	var secret helpers.Secret
+	if len(key) > 0 {
+		if secret, err = decryptSecret(profile, key); err != nil {
+			if log != nil {
+				log.LogError(fmt.Sprintf("Decryption with the master password failed for profile %s", profile.Alias), "decrypt", sessionID, err)
+			}
+			return nil, "", fmt.Errorf("profile %s is not encrypted with the master password", profile.Alias)
+		}
+	} else if agentKey, ok := s.agentKey(profileKeyName(profile)); ok && decryptWithKey(profile, agentKey, &secret) {
+		key = agentKey
 		if log != nil {
-			log.Log(logger.INFO, fmt.Sprintf("Starting decryption for profile: %s", profile.Alias), "decrypt", sessionID)
+			log.Log(logger.INFO, fmt.Sprintf("Used the key cached by the agent for profile %s", profile.Alias), "decrypt", sessionID)
 		}
-
-		for currentTry < maxTries {
-			currentTry++
+	} else {
+		for currentTry := 1; ; currentTry++ {
 			input := pterm.
 				DefaultInteractiveTextInput.
 				WithTextStyle(pterm.NewStyle(pterm.FgDefault)).
 				WithDefaultText(fmt.Sprintf("\nDecryption Key (%s)", profile.Alias))
-			if maskInput {
+			if s.MaskInput {
 				input.Mask = "*"
 			}
 			encKey, _ := input.Show()
-			hash := helpers.CreateHash(encKey)
-			if profile.AuthType == database.AuthTypePassword {
-				if profile.Password, err = helpers.DecryptString(profile.Password, hash); err != nil {
-					if currentTry < maxTries {
-						pterm.Warning.Println("Wrong password, please try again...")
-						if log != nil {
-							log.Log(logger.WARN, fmt.Sprintf("Failed to decrypt password for profile %s, attempt %d/%d", profile.Alias, currentTry, maxTries), "decrypt", sessionID)
-						}
-					} else {
-						if log != nil {
-							log.LogError(fmt.Sprintf("Final decryption attempt failed for profile %s", profile.Alias), "decrypt", sessionID, err)
-						}
-						return err
-					}
-				}
-			} else {
-				if decryptedPrivateKey, err := helpers.DecryptString(string(profile.PrivateKey), hash); err != nil {
-					if currentTry < maxTries {
-						currentTry++
-						pterm.Warning.Println("Wrong password, please try again...")
-						if log != nil {
-							log.Log(logger.WARN, fmt.Sprintf("Failed to decrypt private key for profile %s, attempt %d/%d", profile.Alias, currentTry, maxTries), "decrypt", sessionID)
-						}
-					} else {
-						if log != nil {
-							log.LogError(fmt.Sprintf("Final decryption attempt failed for profile %s", profile.Alias), "decrypt", sessionID, err)
-						}
-						return err
-					}
-				} else {
-					profile.PrivateKey = []byte(decryptedPrivateKey)
-					if log != nil {
-						log.Log(logger.INFO, fmt.Sprintf("Successfully decrypted private key for profile %s", profile.Alias), "decrypt", sessionID)
-					}
+
+			key = helpers.CreateHash(encKey)
+			if secret, err = decryptSecret(profile, key); err == nil {
+				s.rememberKey(profileKeyName(profile), key)
+				break
+			}
+			if currentTry >= s.DecryptionRetries {
+				if log != nil {
+					log.LogError(fmt.Sprintf("Final decryption attempt failed for profile %s", profile.Alias), "decrypt", sessionID, err)
 				}
+				return nil, "", err
+			}
+			pterm.Warning.Println("Wrong password, please try again...")
+			if log != nil {
+				log.Log(logger.WARN, fmt.Sprintf("Failed to decrypt profile %s, attempt %d/%d", profile.Alias, currentTry, s.DecryptionRetries), "decrypt", sessionID)
 			}
 		}
+	}
 
-		if log != nil {
-			log.Log(logger.INFO, fmt.Sprintf("Completed decryption for profile: %s", profile.Alias), "decrypt", sessionID)
+	if legacy {
+		// Failing to upgrade is no reason to refuse the connection, the next decryption tries again
+		if err = s.upgradeSecret(profile.Id, secret, key); err != nil {
+			if log != nil {
+				log.LogError(fmt.Sprintf("Failed to upgrade the encryption of profile %s", profile.Alias), "decrypt", sessionID, err)
+			}
+		} else if log != nil {
+			log.Log(logger.INFO, fmt.Sprintf("Upgraded the encryption of profile %s", profile.Alias), "decrypt", sessionID)
+		}
+	}
+
+	if log != nil {
+		log.Log(logger.INFO, fmt.Sprintf("Completed decryption for profile: %s", profile.Alias), "decrypt", sessionID)
+	}
+	return secret, key, nil
+}
+
+// upgradeSecret re-encrypts the plain secret of a profile that was stored before envelopes existed.
+// The secret is written to the profile that stores it, which is the template if the profile inherits its authentication.
+func (s *ProfileService) upgradeSecret(profileId int64, secret helpers.Secret, key string) error {
+	owner, err := s.Store.GetSSHProfileById(profileId)
+	if err != nil {
+		return err
+	}
+	if len(secretOf(owner)) == 0 && owner.TemplateId != 0 {
+		if owner, err = s.Store.GetSSHProfileById(owner.TemplateId); err != nil {
+			return err
 		}
 	}
+	if !owner.Encrypted || !helpers.IsLegacyCiphertext(secretOf(owner)) {
+		return nil
+	}
+
+	kdf, err := s.keyKDF()
+	if err != nil {
+		return err
+	}
+	if err = encryptSecret(&owner, secret, key, kdf); err != nil {
+		return err
+	}
+	return s.Store.UpdateSSHProfileSecrets([]database.SSHProfile{owner}, nil)
+}
+
+// secretOf returns the password or private key of the profile, depending on its authentication type.
+func secretOf(profile database.SSHProfile) string {
+	if profile.AuthType == database.AuthTypePassword {
+		return profile.Password
+	}
+	return string(profile.PrivateKey)
+}
+
+func setSecret(profile *database.SSHProfile, secret string) {
+	if profile.AuthType == database.AuthTypePassword {
+		profile.Password = secret
+	} else {
+		profile.PrivateKey = []byte(secret)
+	}
+}
+
+// decryptSecret checks the key against the verifier of the profile first, so a wrong key never touches the ciphertext.
+// Profiles encrypted before verifiers existed can only be checked by decrypting them.
+func decryptSecret(profile database.SSHProfile, key string) (helpers.Secret, error) {
+	if len(profile.KeyCheck) > 0 && helpers.KeyCheck(key) != profile.KeyCheck {
+		return nil, errWrongKey
+	}
+	return helpers.DecryptString(secretOf(profile), key)
+}
+
+// decryptWithKey sets secret if key decrypts the profile.
+func decryptWithKey(profile database.SSHProfile, key string, secret *helpers.Secret) bool {
+	decrypted, err := decryptSecret(profile, key)
+	if err != nil {
+		return false
+	}
+	*secret = decrypted
+	return true
+}
+
+// encryptSecret stores the plain secret encrypted with key in the profile.
+func encryptSecret(profile *database.SSHProfile, secret []byte, key string, kdf helpers.KDF) error {
+	sealed, err := helpers.EncryptString(secret, key, kdf)
+	if err != nil {
+		return err
+	}
+	setSecret(profile, sealed)
+	profile.KeyCheck = helpers.KeyCheck(key)
 	return nil
 }
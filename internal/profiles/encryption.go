@@ -0,0 +1,242 @@
+package profiles
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/pkg/helpers"
+	"github.com/mikeunge/sshman/pkg/logger"
+
+	"github.com/pterm/pterm"
+)
+
+// storedProfile returns the profile p refers to, the user selects one if p is empty.
+func (s *ProfileService) storedProfile(p string, text string) (database.SSHProfile, error) {
+	var profileId int64
+	var err error
+
+	if !profileIsProvided(p) {
+		if profileId, err = s.selectProfile(text, 0, true); err != nil {
+			return database.SSHProfile{}, err
+		}
+		fmt.Println()
+	} else if profileId, err = parseProfileIdFromArg(p, s); err != nil {
+		return database.SSHProfile{}, err
+	}
+	return s.Store.GetSSHProfileById(profileId)
+}
+
+// canToggleEncryption checks that the profile stores a secret of its own.
+func canToggleEncryption(profile database.SSHProfile) error {
+	if len(profile.SecretRef) > 0 {
+		return fmt.Errorf("the secret of %s is kept by a secret provider, sshman doesn't store it", profile.Alias)
+	}
+	if !profile.AuthType.StoresSecret() {
+		return fmt.Errorf("%s uses %s authentication, sshman doesn't store a secret for it", profile.Alias, strings.ToLower(database.GetNameFromAuthType(profile.AuthType)))
+	}
+	if len(secretOf(profile)) == 0 {
+		return fmt.Errorf("%s has no secret of its own, change the encryption of its template instead", profile.Alias)
+	}
+	return nil
+}
+
+// newEncryptionKey returns the key new encrypted secrets use, the master password's key in vault mode.
+func (s *ProfileService) newEncryptionKey() (string, helpers.KDF, error) {
+	kdf, err := s.keyKDF()
+	if err != nil {
+		return "", kdf, err
+	}
+	key, err := s.masterKey()
+	if err != nil || len(key) > 0 {
+		return key, kdf, err
+	}
+
+	password, err := s.askNewPassword("Encryption key")
+	if err != nil {
+		return "", kdf, err
+	}
+	return helpers.CreateHash(password), kdf, nil
+}
+
+// encryptRevisions encrypts the secrets the revisions of the profiles store in plain text, otherwise encrypting a profile
+// would leave its secret readable in the database. A leftover secret of another auth type is removed.
+func (s *ProfileService) encryptRevisions(profiles []database.SSHProfile, key string, kdf helpers.KDF) ([]database.ProfileRevision, error) {
+	var encrypted []database.ProfileRevision
+
+	for _, profile := range profiles {
+		revisions, err := s.Store.GetProfileRevisions(profile.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, revision := range revisions {
+			if revision.Profile.Encrypted || (len(revision.Profile.Password) == 0 && len(revision.Profile.PrivateKey) == 0) {
+				continue
+			}
+			secret := secretOf(revision.Profile)
+			revision.Profile.Password, revision.Profile.PrivateKey = "", nil
+			if len(secret) > 0 && revision.Profile.AuthType.StoresSecret() {
+				if err = encryptSecret(&revision.Profile, []byte(secret), key, kdf); err != nil {
+					return nil, err
+				}
+				revision.Profile.Encrypted = true
+			}
+			encrypted = append(encrypted, revision)
+		}
+	}
+	return encrypted, nil
+}
+
+// EncryptProfile encrypts the stored secret of a profile that was created with --no-encrypt.
+func (s *ProfileService) EncryptProfile(p string) error {
+	sessionID := fmt.Sprintf("encrypt_%d", time.Now().Unix())
+
+	profile, err := s.storedProfile(p, "Select profile you want to encrypt")
+	if err != nil {
+		return err
+	}
+	if err = canToggleEncryption(profile); err != nil {
+		return err
+	}
+	if profile.Encrypted {
+		return fmt.Errorf("%s is already encrypted", profile.Alias)
+	}
+
+	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Encrypting: %d %s\n", profile.Id, profile.Alias)
+	key, kdf, err := s.newEncryptionKey()
+	if err != nil {
+		return err
+	}
+	if err = encryptSecret(&profile, []byte(secretOf(profile)), key, kdf); err != nil {
+		return err
+	}
+	profile.Encrypted = true
+	revisions, err := s.encryptRevisions([]database.SSHProfile{profile}, key, kdf)
+	if err != nil {
+		return err
+	}
+
+	if err = s.backup("encrypt"); err != nil {
+		return err
+	}
+	if err = s.Store.UpdateSSHProfileSecrets([]database.SSHProfile{profile}, revisions); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError(fmt.Sprintf("Failed to encrypt profile %s", profile.Alias), "encrypt", sessionID, err)
+		}
+		return err
+	}
+	if len(s.masterKeyCache) == 0 {
+		s.rememberKey(profileKeyName(profile), key)
+	}
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, fmt.Sprintf("Encrypted profile %s", profile.Alias), "encrypt", sessionID)
+	}
+
+	fmt.Println()
+	pterm.Success.Printf("%s and %d revision(s) are now encrypted.\n", profile.Alias, len(revisions))
+	return nil
+}
+
+// EncryptAllProfiles encrypts the stored secrets of all unencrypted profiles and templates with the same key in a single transaction.
+func (s *ProfileService) EncryptAllProfiles() error {
+	sessionID := fmt.Sprintf("encrypt_%d", time.Now().Unix())
+
+	all, err := s.Store.GetAllSSHProfiles()
+	if err != nil {
+		return err
+	}
+	var profiles []database.SSHProfile
+	for _, profile := range all {
+		if !profile.Encrypted && canToggleEncryption(profile) == nil {
+			profiles = append(profiles, profile)
+		}
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("there are no unencrypted profiles")
+	}
+
+	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Encrypting %d profile(s)\n", len(profiles))
+	for _, profile := range profiles {
+		pterm.DefaultBasicText.Printf("  %d %s\n", profile.Id, profile.Alias)
+	}
+	key, kdf, err := s.newEncryptionKey()
+	if err != nil {
+		return err
+	}
+	for i := range profiles {
+		if err = encryptSecret(&profiles[i], []byte(secretOf(profiles[i])), key, kdf); err != nil {
+			return err
+		}
+		profiles[i].Encrypted = true
+	}
+	revisions, err := s.encryptRevisions(profiles, key, kdf)
+	if err != nil {
+		return err
+	}
+
+	if err = s.backup("encrypt"); err != nil {
+		return err
+	}
+	if err = s.Store.UpdateSSHProfileSecrets(profiles, revisions); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to encrypt profiles", "encrypt", sessionID, err)
+		}
+		return fmt.Errorf("could not encrypt the profiles, nothing was changed.\n%s", err.Error())
+	}
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, fmt.Sprintf("Encrypted %d profiles", len(profiles)), "encrypt", sessionID)
+	}
+
+	fmt.Println()
+	pterm.Success.Printf("Encrypted %d profile(s) and %d revision(s).\n", len(profiles), len(revisions))
+	return nil
+}
+
+// DecryptStoredProfile stores the secret of an encrypted profile in plain text.
+func (s *ProfileService) DecryptStoredProfile(p string) error {
+	sessionID := fmt.Sprintf("decrypt_store_%d", time.Now().Unix())
+
+	profile, err := s.storedProfile(p, "Select profile you want to store unencrypted")
+	if err != nil {
+		return err
+	}
+	if err = canToggleEncryption(profile); err != nil {
+		return err
+	}
+	if !profile.Encrypted {
+		return fmt.Errorf("%s isn't encrypted", profile.Alias)
+	}
+
+	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Decrypting: %d %s\n", profile.Id, profile.Alias)
+	secret, err := s.decryptProfile(profile, sessionID)
+	if err != nil {
+		return err
+	}
+	defer secret.Wipe()
+	confirmText := fmt.Sprintf("\nEveryone with access to the database can read the secret of %s, store it unencrypted?", profile.Alias)
+	if ok, _ := pterm.DefaultInteractiveConfirm.WithDefaultText(confirmText).Show(); !ok {
+		pterm.Info.Println("Nothing was changed.")
+		return nil
+	}
+	setSecret(&profile, string(secret))
+	profile.Encrypted = false
+	profile.KeyCheck = ""
+
+	if err = s.backup("decrypt"); err != nil {
+		return err
+	}
+	// The revisions stay encrypted, decrypting the current secret doesn't need to expose the previous ones
+	if err = s.Store.UpdateSSHProfileSecrets([]database.SSHProfile{profile}, nil); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError(fmt.Sprintf("Failed to decrypt profile %s", profile.Alias), "decrypt_store", sessionID, err)
+		}
+		return err
+	}
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, fmt.Sprintf("Stored profile %s unencrypted", profile.Alias), "decrypt_store", sessionID)
+	}
+
+	pterm.Success.Printf("%s is now stored unencrypted.\n", profile.Alias)
+	return nil
+}
@@ -0,0 +1,68 @@
+package profiles
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mikeunge/sshman/internal/database"
+)
+
+// newVaultService returns a service on an empty memory store in vault mode, the master key is already known,
+// so nothing asks for a password. The Argon2id parameters are as small as possible to keep the tests fast.
+func newVaultService(t *testing.T) *ProfileService {
+	t.Helper()
+
+	s := &ProfileService{Store: database.NewMemoryStore(), KDFIterations: 1, KDFMemory: 64, KDFThreads: 1}
+	if err := s.Store.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	vault, key, err := s.newVault("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Store.SaveVault(&vault, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.masterKeyCache = key
+	return s
+}
+
+func TestEncryptProfileEncryptsRevisions(t *testing.T) {
+	s := newVaultService(t)
+
+	id, err := s.Store.CreateSSHProfile(database.SSHProfile{Alias: "web", Host: "example.com", User: "root", Port: 22, AuthType: database.AuthTypePassword, Password: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"second", "third"} {
+		profile, _ := s.Store.GetSSHProfileById(id)
+		profile.Password = password
+		if err = s.Store.UpdateSSHProfileById(id, profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = s.EncryptProfile("web"); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := s.Store.GetProfileRevisions(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("got %d revisions, expected 2", len(revisions))
+	}
+	for _, r := range revisions {
+		if !r.Profile.Encrypted || strings.Contains(r.Profile.Password, "first") || strings.Contains(r.Profile.Password, "second") {
+			t.Fatalf("revision %d still stores the secret in plain text: %q", r.Revision, r.Profile.Password)
+		}
+		secret, err := decryptSecret(r.Profile, s.masterKeyCache)
+		if err != nil {
+			t.Fatalf("revision %d can't be decrypted with the master key: %v", r.Revision, err)
+		}
+		if want := map[int]string{1: "first", 2: "second"}[r.Revision]; string(secret) != want {
+			t.Fatalf("revision %d holds %q, expected %q", r.Revision, secret, want)
+		}
+	}
+}
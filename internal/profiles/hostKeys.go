@@ -0,0 +1,145 @@
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/pkg/helpers"
+	"github.com/mikeunge/sshman/pkg/logger"
+
+	"github.com/pterm/pterm"
+	cryptSSH "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Read in addition to the stored host keys if UseKnownHosts is set
+const knownHostsPath = "~/.ssh/known_hosts"
+
+// hostAddress returns host:port of the profile, the way the host key is stored.
+func hostAddress(profile database.SSHProfile) string {
+	port := profile.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(profile.Host, strconv.Itoa(port))
+}
+
+// hostKeyCallback verifies the host key of the profile's server.
+// Keys listed in ~/.ssh/known_hosts (if enabled) take precedence, otherwise the key is compared to the one stored for the profile.
+// A server without a known key is shown to the user and trusted on first use, a changed key is always refused.
+func (s *ProfileService) hostKeyCallback(profile database.SSHProfile, sessionID string) cryptSSH.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key cryptSSH.PublicKey) error {
+		fingerprint := cryptSSH.FingerprintSHA256(key)
+		address := hostAddress(profile)
+
+		if s.UseKnownHosts {
+			if known, err := knownhosts.New(helpers.SanitizePath(knownHostsPath)); err == nil {
+				var keyErr *knownhosts.KeyError
+				var revokedErr *knownhosts.RevokedError
+				err = known(hostname, remote, key)
+				switch {
+				case err == nil:
+					return nil
+				case errors.As(err, &revokedErr):
+					pterm.Error.Printf("The host key %s of %s is marked as revoked in %s!\n", fingerprint, profile.Alias, knownHostsPath)
+					return fmt.Errorf("the host key of %s is revoked, refusing to connect", profile.Alias)
+				case errors.As(err, &keyErr):
+					// Only a known key of the same type was replaced, if known_hosts lists other types only the stored key decides
+					if want, ok := knownKeyOfType(keyErr.Want, key.Type()); ok {
+						hint := fmt.Sprintf("If the new key is legitimate, remove the old one with 'ssh-keygen -R %s' and connect again.", knownhosts.Normalize(address))
+						s.hostKeyMismatch(profile, sessionID, cryptSSH.FingerprintSHA256(want.Key)+" ("+knownHostsPath+")", fingerprint, hint)
+						return fmt.Errorf("the host key of %s has changed, refusing to connect", profile.Alias)
+					}
+				}
+			} else if s.Logger != nil {
+				s.Logger.LogError("Could not read known hosts", "hostkey", sessionID, err)
+			}
+		}
+
+		stored, err := s.Store.GetHostKey(profile.Id)
+		if err != nil {
+			return err
+		}
+		if stored != nil && stored.Address == address {
+			if stored.Fingerprint == fingerprint {
+				return nil
+			}
+			hint := fmt.Sprintf("If the new key is legitimate, run 'sshman hostkey reset %s' and connect again.", profile.Alias)
+			s.hostKeyMismatch(profile, sessionID, stored.Fingerprint, fingerprint, hint)
+			return fmt.Errorf("the host key of %s has changed, refusing to connect", profile.Alias)
+		}
+
+		fmt.Println()
+		pterm.Info.Printf("The authenticity of %s (%s) can't be established.\n", address, profile.Alias)
+		pterm.DefaultBasicText.Printf("%s key fingerprint is %s\n", key.Type(), fingerprint)
+		if ok, _ := pterm.DefaultInteractiveConfirm.WithDefaultText("Trust this host key and connect?").Show(); !ok {
+			return fmt.Errorf("the host key of %s was not trusted", profile.Alias)
+		}
+
+		if err = s.Store.SaveHostKey(database.HostKey{ProfileId: profile.Id, Address: address, KeyType: key.Type(), Fingerprint: fingerprint}); err != nil {
+			// The user trusted the key, not being able to remember it only means being asked again
+			pterm.Warning.Printf("Could not store the host key of %s, %s\n", profile.Alias, err.Error())
+			if s.Logger != nil {
+				s.Logger.LogError(fmt.Sprintf("Failed to store host key of %s", profile.Alias), "hostkey", sessionID, err)
+			}
+		} else if s.Logger != nil {
+			s.Logger.Log(logger.INFO, fmt.Sprintf("Trusted host key %s of %s (%s)", fingerprint, profile.Alias, address), "hostkey", sessionID)
+		}
+		return nil
+	}
+}
+
+// knownKeyOfType returns the first of the known keys with the given type.
+func knownKeyOfType(known []knownhosts.KnownKey, keyType string) (knownhosts.KnownKey, bool) {
+	for _, k := range known {
+		if k.Key.Type() == keyType {
+			return k, true
+		}
+	}
+	return knownhosts.KnownKey{}, false
+}
+
+// hostKeyMismatch warns the user that the server presented a different key than the trusted one.
+func (s *ProfileService) hostKeyMismatch(profile database.SSHProfile, sessionID string, expected string, received string, hint string) {
+	fmt.Println()
+	pterm.Error.Printf("THE HOST KEY OF %s (%s) HAS CHANGED!\n", profile.Alias, hostAddress(profile))
+	pterm.Warning.Println("Someone could be eavesdropping on you right now (man-in-the-middle attack), or the host key was just replaced.")
+	pterm.DefaultBasicText.Printf("Expected: %s\nReceived: %s\n", expected, received)
+	pterm.Info.Println(hint)
+
+	if s.Logger != nil {
+		s.Logger.LogError(fmt.Sprintf("Host key of %s has changed, expected %s", profile.Alias, expected), "hostkey", sessionID, fmt.Errorf("received host key %s", received))
+	}
+}
+
+// ResetHostKey forgets the trusted host key of a profile, the key of the server is shown and trusted again on the next connect.
+func (s *ProfileService) ResetHostKey(p string) error {
+	sessionID := fmt.Sprintf("hostkey_%d", time.Now().Unix())
+
+	profile, err := s.storedProfile(p, "Select profile you want to reset the host key for")
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.Store.DeleteHostKey(profile.Id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		pterm.Info.Printf("There is no host key stored for %s.\n", profile.Alias)
+		return nil
+	}
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, fmt.Sprintf("Reset host key of %s", profile.Alias), "hostkey", sessionID)
+	}
+
+	pterm.Success.Printf("Forgot the host key of %s, it has to be trusted again on the next connect.\n", profile.Alias)
+	if s.UseKnownHosts {
+		pterm.Info.Printf("Entries in %s aren't changed.\n", knownHostsPath)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package profiles
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	cryptSSH "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestKnownKeyOfType(t *testing.T) {
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := cryptSSH.NewPublicKey(edPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := cryptSSH.NewPublicKey(&ecPrivate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		known   []knownhosts.KnownKey
+		keyType string
+		found   bool
+	}{
+		{"no known keys", nil, cryptSSH.KeyAlgoED25519, false},
+		{"only another type is known", []knownhosts.KnownKey{{Key: ecKey}}, cryptSSH.KeyAlgoED25519, false},
+		{"the same type is known", []knownhosts.KnownKey{{Key: edKey}}, cryptSSH.KeyAlgoED25519, true},
+		{"the same type is known among others", []knownhosts.KnownKey{{Key: ecKey}, {Key: edKey}}, cryptSSH.KeyAlgoED25519, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, ok := knownKeyOfType(tt.known, tt.keyType)
+			if ok != tt.found {
+				t.Fatalf("got found=%v, expected %v", ok, tt.found)
+			}
+			if ok && want.Key.Type() != tt.keyType {
+				t.Fatalf("got a %s key, expected %s", want.Key.Type(), tt.keyType)
+			}
+		})
+	}
+}
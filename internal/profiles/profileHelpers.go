@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/pkg/helpers"
 	"github.com/mikeunge/sshman/pkg/logger"
 	"github.com/mikeunge/sshman/pkg/ssh"
 	"github.com/pterm/pterm"
@@ -58,29 +59,55 @@ func parseProfileIdFromArg(p string, s *ProfileService) (int64, error) {
 		return profileId, nil
 	}
 
-	profile, err := s.DB.GetSSHProfileByAlias(p)
+	profile, err := s.Store.GetSSHProfileByAlias(p)
 	if err != nil {
 		return 0, err
 	}
 	return profile.Id, nil
 }
 
-func (s *ProfileService) connect(profile *database.SSHProfile) error {
+// backup creates an automatic backup before a destructive operation, only the sqlite backend supports them.
+func (s *ProfileService) backup(reason string) error {
+	_, err := s.backupPath(reason)
+	return err
+}
+
+// backupPath works like backup and returns the path of the backup, it is empty if no backup was taken.
+func (s *ProfileService) backupPath(reason string) (string, error) {
+	db, ok := s.Store.(*database.DB)
+	if !ok {
+		return "", nil
+	}
+
+	path, err := db.AutoBackup(reason)
+	if err != nil {
+		return "", fmt.Errorf("could not create a backup before the %s, %s", reason, err.Error())
+	}
+	if s.Logger != nil && len(path) > 0 {
+		s.Logger.Log(logger.INFO, fmt.Sprintf("Created backup %s", path), reason, fmt.Sprintf("backup_%d", time.Now().Unix()))
+	}
+	return path, nil
+}
+
+// connect opens a shell on the server, the secret is wiped once the handshake is done.
+func (s *ProfileService) connect(profile *database.SSHProfile, secret helpers.Secret) error {
 	sessionStart := time.Now()
 	sessionID := fmt.Sprintf("session_%d", sessionStart.Unix())
 
 	server := ssh.SSHServer{
-		User: profile.User,
-		Host: profile.Host,
-		SecureConnection: false,
-		Logger: s.Logger,
-		SessionID: sessionID,
+		User:            profile.User,
+		Host:            profile.Host,
+		Port:            uint(profile.Port),
+		HostKeyCallback: s.hostKeyCallback(*profile, sessionID),
+		Logger:          s.Logger,
+		SessionID:       sessionID,
+		Env:             profile.Env,
 	}
 
 	if s.Logger != nil {
 		s.Logger.LogWithDetails(
 			logger.INFO,
-			fmt.Sprintf("Attempting to connect to %s@%s", profile.User, profile.Host),
+			fmt.Sprintf("Attempting to connect to %s@%s:%d", profile.User, profile.Host, profile.Port),
 			"connect",
 			sessionID,
 			"",
@@ -90,18 +117,36 @@ func (s *ProfileService) connect(profile *database.SSHProfile) error {
 		)
 	}
 
-	if profile.AuthType == database.AuthTypePrivateKey {
-		if err := server.ConnectSSHServerWithPrivateKey(profile.PrivateKey); err != nil {
+	if profile.AuthType == database.AuthTypeAgent {
+		if err := server.ConnectSSHServerWithAgent(profile.AgentKey); err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError(fmt.Sprintf("Connection failed to %s@%s", profile.User, profile.Host), "connect", sessionID, err)
+			}
+			s.recordSession(profile, sessionStart, -1, err)
+			return err
+		}
+	} else if profile.AuthType == database.AuthTypeKeyboard {
+		if err := server.ConnectSSHServerWithKeyboardInteractive(); err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError(fmt.Sprintf("Connection failed to %s@%s", profile.User, profile.Host), "connect", sessionID, err)
+			}
+			s.recordSession(profile, sessionStart, -1, err)
+			return err
+		}
+	} else if profile.AuthType == database.AuthTypePrivateKey {
+		if err := server.ConnectSSHServerWithPrivateKey(secret); err != nil {
 			if s.Logger != nil {
 				s.Logger.LogError(fmt.Sprintf("Connection failed to %s@%s", profile.User, profile.Host), "connect", sessionID, err)
 			}
+			s.recordSession(profile, sessionStart, -1, err)
 			return err
 		}
 	} else {
-		if err := server.ConnectSSHServerWithPassword(profile.Password); err != nil {
+		if err := server.ConnectSSHServerWithPassword(secret); err != nil {
 			if s.Logger != nil {
 				s.Logger.LogError(fmt.Sprintf("Connection failed to %s@%s", profile.User, profile.Host), "connect", sessionID, err)
 			}
+			s.recordSession(profile, sessionStart, -1, err)
 			return err
 		}
 	}
@@ -135,13 +180,16 @@ func (s *ProfileService) connect(profile *database.SSHProfile) error {
 		s.Logger.Log(logger.INFO, "Starting interactive shell", "connect", sessionID)
 	}
 
+	shellDone := make(chan error, 1)
 	go func() {
-		if err := server.SpawnShell(ctx); err != nil {
+		err := server.SpawnShell(ctx)
+		if err != nil {
 			if s.Logger != nil {
 				s.Logger.LogError("Error in shell session", "connect", sessionID, err)
 			}
 			pterm.Error.Printf("%s\n", err.Error())
 		}
+		shellDone <- err
 		cancel()
 	}()
 
@@ -157,17 +205,18 @@ func (s *ProfileService) connect(profile *database.SSHProfile) error {
 		}
 	}
 
-	diff := time.Now().Sub(sessionStart)
-	durationArr := strings.Split(time.Time{}.Add(diff).Format("15:04:05"), ":")
-
-	var duration string
-	if durationArr[0] != "00" {
-		duration = fmt.Sprintf("%sh %sm %ss", durationArr[0], durationArr[1], durationArr[2])
-	} else if durationArr[1] != "00" {
-		duration = fmt.Sprintf("%sm %ss", durationArr[1], durationArr[2])
-	} else {
-		duration = fmt.Sprintf("%ss", durationArr[2])
+	// The shell didn't finish when the session got interrupted by a signal
+	exitStatus := -1
+	var shellErr error
+	select {
+	case shellErr = <-shellDone:
+		exitStatus = server.ExitStatus
+	default:
 	}
+	s.recordSession(profile, sessionStart, exitStatus, shellErr)
+
+	diff := time.Now().Sub(sessionStart)
+	duration := formatDuration(diff)
 
 	if s.Logger != nil {
 		s.Logger.LogWithDetails(
@@ -186,6 +235,38 @@ func (s *ProfileService) connect(profile *database.SSHProfile) error {
 	return nil
 }
 
+// recordSession persists a (failed) session in the connection history.
+// The session already happened at this point, so errors are only logged.
+func (s *ProfileService) recordSession(profile *database.SSHProfile, start time.Time, exitStatus int, sessionErr error) {
+	end := time.Now()
+	entry := database.ConnectionHistory{
+		ProfileId:  profile.Id,
+		Start:      start,
+		End:        end,
+		Duration:   end.Sub(start),
+		ExitStatus: exitStatus,
+	}
+	if sessionErr != nil {
+		entry.Error = sessionErr.Error()
+	}
+
+	if _, err := s.Store.CreateConnectionHistory(entry); err != nil && s.Logger != nil {
+		s.Logger.LogError(fmt.Sprintf("Failed to record session for profile %s", profile.Alias), "connect", "", err)
+	}
+}
+
+// formatDuration formats a duration as "1h 02m 03s", leading zero units are omitted.
+func formatDuration(d time.Duration) string {
+	durationArr := strings.Split(time.Time{}.Add(d).Format("15:04:05"), ":")
+
+	if durationArr[0] != "00" {
+		return fmt.Sprintf("%sh %sm %ss", durationArr[0], durationArr[1], durationArr[2])
+	} else if durationArr[1] != "00" {
+		return fmt.Sprintf("%sm %ss", durationArr[1], durationArr[2])
+	}
+	return fmt.Sprintf("%ss", durationArr[2])
+}
+
 // parseSCPPath parses a path in the format "identifier:path" to extract identifier and path
 func parseSCPPath(path string) (identifier, filePath string, err error) {
 	// Look for the first colon to split identifier and path
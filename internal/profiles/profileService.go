@@ -3,75 +3,108 @@ package profiles
 import (
 	"encoding/csv"
 	"fmt"
-	"log"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/mikeunge/sshman/internal/agent"
 	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/internal/secrets"
 	"github.com/mikeunge/sshman/pkg/helpers"
 	"github.com/mikeunge/sshman/pkg/logger"
 	"github.com/mikeunge/sshman/pkg/scp"
 	"github.com/mikeunge/sshman/pkg/ssh"
 
+	"atomicgo.dev/keyboard/keys"
 	input_autocomplete "github.com/JoaoDanielRufino/go-input-autocomplete"
 	"github.com/pterm/pterm"
 )
 
 type ProfileService struct {
-	DB                *database.DB
+	Store             database.Store
 	KeyPath           string
 	MaskInput         bool
 	DecryptionRetries int
-	Logger          *logger.Logger
+	Logger            *logger.Logger
+	TagFilter         []string // only profiles with all of these tags are listed or selectable
+	TrashRetention    time.Duration
+
+	// Argon2id parameters used when the vault gets created
+	KDFIterations uint32
+	KDFMemory     uint32 // in KiB
+	KDFThreads    uint8
+
+	Agent *agent.Client // asked for cached keys before prompting, nil disables the agent
+
+	SecretProviders map[string]secrets.Provider // by name, profiles reference them in their SecretRef
+
+	UseKnownHosts bool // trust the host keys in ~/.ssh/known_hosts in addition to the ones stored per profile
+
+	masterKeyCache string // the derived vault key, the master password is only asked for once. It isn't wiped, it lives as long as the process
 }
 
 func (s *ProfileService) NewProfile(skipEncryption bool) error {
+	return s.createProfile(skipEncryption, false)
+}
+
+// createProfile asks for all settings of a new profile or template.
+// Profiles that inherit from a template may leave everything but the host and alias empty.
+func (s *ProfileService) createProfile(skipEncryption bool, asTemplate bool) error {
 	startTime := time.Now()
 	sessionID := fmt.Sprintf("new_profile_%d", startTime.Unix())
 
+	kind := "profile"
+	if asTemplate {
+		kind = "template"
+	}
+
 	if s.Logger != nil {
-		s.Logger.Log(logger.INFO, "Starting new profile creation", "new", sessionID)
+		s.Logger.Log(logger.INFO, fmt.Sprintf("Starting new %s creation", kind), "new", sessionID)
 	}
 
 	var (
-		encKey  string
-		profile database.SSHProfile
+		template *database.SSHProfile
+		profile  = database.SSHProfile{IsTemplate: asTemplate}
+		err      error
 	)
 
-	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Println("Creating new ssh profile")
+	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Creating new ssh %s\n", kind)
 	writer := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault))
 
-	if !skipEncryption {
-		writer.DefaultText = "Encryption key"
-		if s.MaskInput {
-			writer.Mask = "*"
+	if !asTemplate {
+		if template, err = s.selectTemplate(); err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError("Failed to select template", "new", sessionID, err)
+			}
+			return err
+		}
+		if template != nil {
+			profile.TemplateId = template.Id
 		}
-		encKey, _ = writer.Show()
-		encKey = helpers.CreateHash(encKey)
-		profile.Encrypted = true
-		writer.Mask = ""
 	}
 
-	user, err := parseAndVerifyInput(writer.WithDefaultText("User"), validateUser)
-	if err != nil {
-		if s.Logger != nil {
-			s.Logger.LogError("Failed to parse user input", "new", sessionID, err)
-		}
+	prompts := s.newProfilePrompts(template, "new", sessionID)
+	if profile.User, err = prompts.user(""); err != nil {
 		return err
 	}
-	profile.User = user
 
-	host, err := parseAndVerifyInput(writer.WithDefaultText("Host"), validateHost)
-	if err != nil {
-		if s.Logger != nil {
-			s.Logger.LogError("Failed to parse host input", "new", sessionID, err)
+	if !asTemplate {
+		if profile.Host, err = prompts.host(""); err != nil {
+			return err
 		}
+	}
+
+	if profile.Port, err = prompts.port(0); err != nil {
 		return err
 	}
-	profile.Host = host
 
-	alias, err := parseAndVerifyInput(writer.WithDefaultText("Alias"), validateAlias)
+	aliasText := "Alias"
+	if asTemplate {
+		aliasText = "Template name"
+	}
+	alias, err := parseAndVerifyInput(writer.WithDefaultText(aliasText), validateAlias)
 	if err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to parse alias input", "new", sessionID, err)
@@ -80,98 +113,158 @@ func (s *ProfileService) NewProfile(skipEncryption bool) error {
 	}
 	profile.Alias = alias
 
-	authTypeOptions := []string{"Password", "Private Key"}
-	selectedOption, _ := pterm.DefaultInteractiveSelect.WithDefaultText("What kind of authentication do you need?").WithOptions(authTypeOptions).Show()
-	authType, err := database.GetAuthTypeFromName(selectedOption)
-	if err != nil {
-		if s.Logger != nil {
-			s.Logger.LogError("Failed to parse authentication type", "new", sessionID, err)
-		}
-		return err
+	const (
+		inheritAuth = "Inherit from template"
+		noAuth      = "None (set by the profiles)"
+	)
+	authTypeOptions := []string{"Password", "Private Key", "Agent", "Keyboard Interactive"}
+	if template != nil && template.HasAuth() {
+		authTypeOptions = append([]string{inheritAuth}, authTypeOptions...)
+	} else if template != nil {
+		pterm.Info.Printf("The template %s doesn't set an authentication, the profile needs its own.\n", template.Alias)
+	} else if asTemplate {
+		authTypeOptions = append(authTypeOptions, noAuth)
 	}
-	profile.AuthType = authType
+	selectedOption, _ := pterm.DefaultInteractiveSelect.WithDefaultText("What kind of authentication do you need?").WithOptions(authTypeOptions).Show()
 
-	var auth string
-	if authType == database.AuthTypePassword {
-		input := writer.WithDefaultText("Password")
-		if s.MaskInput {
-			input.Mask = "*"
-		}
-		auth, err = parseAndVerifyInput(input, validatePassword)
+	if selectedOption != inheritAuth && selectedOption != noAuth {
+		authType, err := database.GetAuthTypeFromName(selectedOption)
 		if err != nil {
 			if s.Logger != nil {
-				s.Logger.LogError("Failed to parse password input", "new", sessionID, err)
+				s.Logger.LogError("Failed to parse authentication type", "new", sessionID, err)
 			}
 			return err
 		}
+		profile.AuthType = authType
 
-		if !skipEncryption {
-			auth, err = helpers.EncryptString(auth, encKey)
-			if err != nil {
+		if profile.AuthType == database.AuthTypeAgent {
+			if profile.AgentKey, err = s.selectAgentKey(""); err != nil {
 				if s.Logger != nil {
-					s.Logger.LogError("Failed to encrypt password", "new", sessionID, err)
+					s.Logger.LogError("Failed to select ssh-agent key", "new", sessionID, err)
 				}
 				return err
 			}
-		}
-		profile.Password = auth
-	} else {
-		if auth, err = input_autocomplete.Read("Path to keyfile: "); err != nil {
+		} else if !profile.AuthType.StoresSecret() {
+			pterm.Info.Println("The prompts of the server are answered when connecting, nothing is stored.")
+		} else if profile.SecretRef, err = s.selectSecretRef(); err != nil {
 			if s.Logger != nil {
-				s.Logger.LogError("Failed to read keyfile path", "new", sessionID, err)
+				s.Logger.LogError("Failed to select secret provider", "new", sessionID, err)
 			}
 			return err
 		}
-		if !helpers.FileExists(helpers.SanitizePath(auth)) {
-			err := fmt.Errorf("file %s does not exist", auth)
-			if s.Logger != nil {
-				s.Logger.LogError(err.Error(), "new", sessionID, err)
+	}
+
+	// Secrets of a secret provider are resolved when connecting, the ssh-agent and keyboard-interactive don't need one
+	if len(profile.SecretRef) == 0 && profile.AuthType.StoresSecret() && selectedOption != inheritAuth && selectedOption != noAuth {
+		var encKey string
+		var kdf helpers.KDF
+		if !skipEncryption {
+			// In vault mode every profile is encrypted with the master password
+			if encKey, err = s.masterKey(); err != nil {
+				if s.Logger != nil {
+					s.Logger.LogError("Failed to unlock the vault", "new", sessionID, err)
+				}
+				return err
 			}
-			return err
-		}
-		data, err := helpers.ReadFile(auth)
-		if err != nil {
-			if s.Logger != nil {
-				s.Logger.LogError("Failed to read keyfile", "new", sessionID, err)
+			if len(encKey) == 0 {
+				// A typo would lock the profile forever, so the key has to be entered twice
+				if encKey, err = s.askNewPassword("Encryption key"); err != nil {
+					if s.Logger != nil {
+						s.Logger.LogError("Failed to read encryption key", "new", sessionID, err)
+					}
+					return err
+				}
+				encKey = helpers.CreateHash(encKey)
 			}
-			return err
+			if kdf, err = s.keyKDF(); err != nil {
+				return err
+			}
+			profile.Encrypted = true
+			profile.KeyCheck = helpers.KeyCheck(encKey)
 		}
-		if !skipEncryption {
-			if encData, err := helpers.EncryptString(string(data), encKey); err != nil {
+
+		var auth string
+		if profile.AuthType == database.AuthTypePassword {
+			input := writer.WithDefaultText("Password")
+			if s.MaskInput {
+				input.Mask = "*"
+			}
+			auth, err = parseAndVerifyInput(input, validatePassword)
+			if err != nil {
 				if s.Logger != nil {
-					s.Logger.LogError("Failed to encrypt keyfile", "new", sessionID, err)
+					s.Logger.LogError("Failed to parse password input", "new", sessionID, err)
 				}
 				return err
-			} else {
-				data = []byte(encData)
 			}
+
+			if !skipEncryption {
+				auth, err = helpers.EncryptString([]byte(auth), encKey, kdf)
+				if err != nil {
+					if s.Logger != nil {
+						s.Logger.LogError("Failed to encrypt password", "new", sessionID, err)
+					}
+					return err
+				}
+			}
+			profile.Password = auth
+		} else {
+			if auth, err = input_autocomplete.Read("Path to keyfile: "); err != nil {
+				if s.Logger != nil {
+					s.Logger.LogError("Failed to read keyfile path", "new", sessionID, err)
+				}
+				return err
+			}
+			if !helpers.FileExists(helpers.SanitizePath(auth)) {
+				err := fmt.Errorf("file %s does not exist", auth)
+				if s.Logger != nil {
+					s.Logger.LogError(err.Error(), "new", sessionID, err)
+				}
+				return err
+			}
+			data, err := helpers.ReadFile(auth)
+			if err != nil {
+				if s.Logger != nil {
+					s.Logger.LogError("Failed to read keyfile", "new", sessionID, err)
+				}
+				return err
+			}
+			if !skipEncryption {
+				if encData, err := helpers.EncryptString(data, encKey, kdf); err != nil {
+					if s.Logger != nil {
+						s.Logger.LogError("Failed to encrypt keyfile", "new", sessionID, err)
+					}
+					return err
+				} else {
+					clear(data)
+					data = []byte(encData)
+				}
+			}
+			profile.PrivateKey = data
 		}
-		profile.PrivateKey = data
 	}
 
-	// Ask for startup command
-	startupCmd, err := parseAndVerifyInput(writer.WithDefaultText("Startup Command (optional, press Enter to skip)"), func(cmd string) (string, error) {
-		// Allow empty commands
-		return cmd, nil
-	})
-	if err != nil {
-		if s.Logger != nil {
-			s.Logger.LogError("Failed to parse startup command", "new", sessionID, err)
+	if profile.StartupCommand, err = prompts.startupCommand(""); err != nil {
+		return err
+	}
+
+	if asTemplate {
+		if profile.Env, err = prompts.env(nil); err != nil {
+			return err
 		}
+	} else if profile.Tags, err = prompts.tags(nil); err != nil {
 		return err
 	}
-	profile.StartupCommand = startupCmd
 
-	if create, _ := pterm.DefaultInteractiveConfirm.WithDefaultText("\nCreate new profile?").Show(); !create {
+	if create, _ := pterm.DefaultInteractiveConfirm.WithDefaultText(fmt.Sprintf("\nCreate new %s?", kind)).Show(); !create {
 		fmt.Println()
-		pterm.Info.Println("Profile creation aborted, exiting.")
+		pterm.Info.Printf("%s creation aborted, exiting.\n", strings.ToUpper(kind[:1])+kind[1:])
 		if s.Logger != nil {
-			s.Logger.Log(logger.INFO, "Profile creation aborted by user", "new", sessionID)
+			s.Logger.Log(logger.INFO, fmt.Sprintf("%s creation aborted by user", kind), "new", sessionID)
 		}
 		return nil
 	}
 
-	id, err := s.DB.CreateSSHProfile(profile)
+	id, err := s.Store.CreateSSHProfile(profile)
 	if err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to create profile in database", "new", sessionID, err)
@@ -183,11 +276,11 @@ func (s *ProfileService) NewProfile(skipEncryption bool) error {
 	duration := endTime.Sub(startTime)
 
 	if s.Logger != nil {
-		s.Logger.LogWithDetails(logger.INFO, fmt.Sprintf("Successfully created profile: ID %d - %s", id, profile.Alias), "new", sessionID, duration.String(), startTime, endTime, nil)
+		s.Logger.LogWithDetails(logger.INFO, fmt.Sprintf("Successfully created %s: ID %d - %s", kind, id, profile.Alias), "new", sessionID, duration.String(), startTime, endTime, nil)
 	}
 
 	fmt.Println()
-	pterm.Info.Printf("Successfully created profile: ID %d - %s\n", id, profile.Alias)
+	pterm.Info.Printf("Successfully created %s: ID %d - %s\n", kind, id, profile.Alias)
 	return nil
 }
 
@@ -208,7 +301,7 @@ func (s *ProfileService) UpdateProfile(p string) error {
 	)
 
 	if !profileIsProvided(p) {
-		if profileId, err = s.selectProfile("Select profile you want to update", 0); err != nil {
+		if profileId, err = s.selectProfile("Select profile you want to update", 0, true); err != nil {
 			if s.Logger != nil {
 				s.Logger.LogError("Failed to select profile", "update", sessionID, err)
 			}
@@ -224,7 +317,7 @@ func (s *ProfileService) UpdateProfile(p string) error {
 		}
 	}
 
-	if profile, err = s.DB.GetSSHProfileById(profileId); err != nil {
+	if profile, err = s.Store.GetSSHProfileById(profileId); err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to get profile by ID", "update", sessionID, err)
 		}
@@ -235,57 +328,79 @@ func (s *ProfileService) UpdateProfile(p string) error {
 		s.Logger.Log(logger.INFO, fmt.Sprintf("Updating profile: %s (%s@%s)", profile.Alias, profile.User, profile.Host), "update", sessionID)
 	}
 
+	// Profiles of a template are edited without the inherited values, empty fields keep inheriting
+	var template *database.SSHProfile
+	if profile.TemplateId != 0 {
+		t, err := s.Store.GetSSHProfileById(profile.TemplateId)
+		if err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError("Failed to get template by ID", "update", sessionID, err)
+			}
+			return err
+		}
+		template = &t
+	}
+
 	// Store original encrypted values to preserve them when not updating
 	originalEncryptedPassword := profile.Password
 	originalEncryptedPrivateKey := profile.PrivateKey
 	originalEncryptedFlag := profile.Encrypted
+	inheritsWithoutSecret := false
+	if template != nil && !profile.HasAuth() {
+		if !template.AuthType.StoresSecret() {
+			// There is nothing a profile could override, setting its own agent key would stop inheriting
+			inheritsWithoutSecret = true
+		} else {
+			// A new secret is stored the same way the inherited one is
+			profile.AuthType = template.AuthType
+			originalEncryptedFlag = template.Encrypted
+		}
+	}
 
 	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Updating: %d %s\n", profile.Id, profile.Alias)
 	writer := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault))
-	if err = decryptProfile(&profile, s.MaskInput, s.DecryptionRetries, s.Logger, sessionID); err != nil {
+	// The secret itself isn't needed, decrypting it makes sure only the owner of the key can update the profile.
+	// The verified key encrypts a new secret if the user keeps the original key.
+	keyOwner := profile
+	if template != nil && !profile.HasAuth() {
+		keyOwner = *template
+	}
+	secret, originalKey, err := s.decryptProfileKey(profile, sessionID)
+	if err != nil {
 		errMsg := fmt.Sprintf("encountered decryption error %+v", err)
 		if s.Logger != nil {
 			s.Logger.LogError(errMsg, "update", sessionID, err)
 		}
 		return fmt.Errorf(errMsg)
 	}
+	secret.Wipe()
 
 	fmt.Println()
-	user, err := parseAndVerifyInput(writer.WithDefaultText("User").WithDefaultValue(profile.User), func(t string) (string, error) {
-		result, err := validateUser(t)
-		if err != nil {
-			return result, err
-		}
-		if t != profile.User {
-			updatedEntries++
-		}
-		return result, nil
-	})
-	if err != nil {
-		if s.Logger != nil {
-			s.Logger.LogError("Failed to parse user input", "update", sessionID, err)
-		}
+	prompts := s.newProfilePrompts(template, "update", sessionID)
+	if updatedProfile.User, err = prompts.user(profile.User); err != nil {
 		return err
 	}
-	updatedProfile.User = user
+	if updatedProfile.User != profile.User {
+		updatedEntries++
+	}
 
-	host, err := parseAndVerifyInput(writer.WithDefaultText("Host").WithDefaultValue(profile.Host), func(h string) (string, error) {
-		result, err := validateHost(h)
-		if err != nil {
-			return result, err
+	// Templates don't have a host
+	updatedProfile.Host = profile.Host
+	if !profile.IsTemplate {
+		if updatedProfile.Host, err = prompts.host(profile.Host); err != nil {
+			return err
 		}
-		if h != profile.Host {
+		if updatedProfile.Host != profile.Host {
 			updatedEntries++
 		}
-		return result, nil
-	})
-	if err != nil {
-		if s.Logger != nil {
-			s.Logger.LogError("Failed to parse host input", "update", sessionID, err)
-		}
+	}
+
+	if updatedProfile.Port, err = prompts.port(profile.Port); err != nil {
 		return err
 	}
-	updatedProfile.Host = host
+	if updatedProfile.Port != profile.Port {
+		updatedEntries++
+	}
 
 	alias, err := parseAndVerifyInput(writer.WithDefaultText("Alias").WithDefaultValue(profile.Alias), func(t string) (string, error) {
 		result, err := validateAlias(t)
@@ -305,33 +420,45 @@ func (s *ProfileService) UpdateProfile(p string) error {
 	}
 	updatedProfile.Alias = alias
 	updatedProfile.AuthType = profile.AuthType
+	updatedProfile.TemplateId = profile.TemplateId
 
-	// Handle authentication update (password or private key)
-	if err := s.updateAuth(profile, &updatedProfile, &updatedEntries, originalEncryptedPassword, originalEncryptedPrivateKey, originalEncryptedFlag); err != nil {
+	// Handle authentication update (password, private key or ssh-agent key)
+	if inheritsWithoutSecret {
+		pterm.Info.Printf("The %s authentication is inherited from %s.\n", strings.ToLower(database.GetNameFromAuthType(template.AuthType)), template.Alias)
+	} else if err := s.updateAuth(profile, &updatedProfile, &updatedEntries, originalEncryptedPassword, originalEncryptedPrivateKey, originalEncryptedFlag, originalKey, keyOwner); err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to update authentication", "update", sessionID, err)
 		}
 		return err
 	}
+	if template != nil && !template.HasAuth() {
+		// Nothing to inherit, the profile has to keep an authentication of its own
+		if err := requireAuth(updatedProfile); err != nil {
+			return err
+		}
+	}
 
-	// Handle startup command update
-	writerWithDefault := writer.WithDefaultText("Startup Command (press Enter to keep current)").WithDefaultValue(profile.StartupCommand)
-	newStartupCmd, err := parseAndVerifyInput(writerWithDefault, func(cmd string) (string, error) {
-		// Allow empty commands
-		return cmd, nil
-	})
-	if err != nil {
-		if s.Logger != nil {
-			s.Logger.LogError("Failed to parse startup command", "update", sessionID, err)
+	if updatedProfile.StartupCommand, err = prompts.startupCommand(profile.StartupCommand); err != nil {
+		return err
+	}
+	if updatedProfile.StartupCommand != profile.StartupCommand {
+		updatedEntries++
+	}
+
+	if !profile.IsTemplate {
+		if updatedProfile.Tags, err = prompts.tags(profile.Tags); err != nil {
+			return err
+		}
+		if !slices.Equal(updatedProfile.Tags, profile.Tags) {
+			updatedEntries++
 		}
+	}
+
+	if updatedProfile.Env, err = prompts.env(profile.Env); err != nil {
 		return err
 	}
-	if newStartupCmd != profile.StartupCommand {
-		updatedProfile.StartupCommand = newStartupCmd
+	if formatEnv(updatedProfile.Env) != formatEnv(profile.Env) {
 		updatedEntries++
-	} else {
-		// Keep the original startup command
-		updatedProfile.StartupCommand = profile.StartupCommand
 	}
 
 	if updatedEntries == 0 {
@@ -352,7 +479,7 @@ func (s *ProfileService) UpdateProfile(p string) error {
 		return nil
 	}
 
-	if err := s.DB.UpdateSSHProfileById(profile.Id, updatedProfile); err != nil {
+	if err := s.Store.UpdateSSHProfileById(profile.Id, updatedProfile); err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to update profile in database", "update", sessionID, err)
 		}
@@ -371,11 +498,148 @@ func (s *ProfileService) UpdateProfile(p string) error {
 	return nil
 }
 
+// CloneProfile copies a profile under a new alias, the secret is copied as stored so it doesn't have to be decrypted.
+func (s *ProfileService) CloneProfile(p string) error {
+	startTime := time.Now()
+	sessionID := fmt.Sprintf("clone_%d", startTime.Unix())
+
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, "Starting profile clone", "clone", sessionID)
+	}
+
+	var (
+		profileId int64
+		err       error
+	)
+
+	if !profileIsProvided(p) {
+		if profileId, err = s.selectProfile("Select profile you want to clone", 0, true); err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError("Failed to select profile", "clone", sessionID, err)
+			}
+			return err
+		}
+		fmt.Println()
+	} else {
+		if profileId, err = parseProfileIdFromArg(p, s); err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError("Failed to parse profile ID", "clone", sessionID, err)
+			}
+			return err
+		}
+	}
+
+	profile, err := s.Store.GetSSHProfileById(profileId)
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to get profile by ID", "clone", sessionID, err)
+		}
+		return err
+	}
+
+	var template *database.SSHProfile
+	if profile.TemplateId != 0 {
+		t, err := s.Store.GetSSHProfileById(profile.TemplateId)
+		if err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError("Failed to get template by ID", "clone", sessionID, err)
+			}
+			return err
+		}
+		template = &t
+	}
+
+	// The clone keeps the authentication, template and (for templates) the template flag of the original
+	clone := profile
+	clone.Id = 0
+
+	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Cloning: %d %s\n", profile.Id, profile.Alias)
+	writer := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault))
+
+	fmt.Println()
+	prompts := s.newProfilePrompts(template, "clone", sessionID)
+	if clone.User, err = prompts.user(profile.User); err != nil {
+		return err
+	}
+
+	if !profile.IsTemplate {
+		if clone.Host, err = prompts.host(profile.Host); err != nil {
+			return err
+		}
+	}
+
+	if clone.Port, err = prompts.port(profile.Port); err != nil {
+		return err
+	}
+
+	if clone.Alias, err = parseAndVerifyInput(writer.WithDefaultText("Alias of the clone"), func(t string) (string, error) {
+		if t == profile.Alias {
+			return t, fmt.Errorf("the clone needs a different alias")
+		}
+		return validateAlias(t)
+	}); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to parse alias input", "clone", sessionID, err)
+		}
+		return err
+	}
+
+	if len(profile.Password) > 0 || len(profile.PrivateKey) > 0 {
+		encrypted := ""
+		if profile.Encrypted {
+			encrypted = ", it stays encrypted with the same key"
+		}
+		pterm.Info.Printf("The %s is copied from %s%s.\n", strings.ToLower(database.GetNameFromAuthType(profile.AuthType)), profile.Alias, encrypted)
+	}
+
+	if clone.StartupCommand, err = prompts.startupCommand(profile.StartupCommand); err != nil {
+		return err
+	}
+
+	if !profile.IsTemplate {
+		if clone.Tags, err = prompts.tags(profile.Tags); err != nil {
+			return err
+		}
+	}
+
+	if clone.Env, err = prompts.env(profile.Env); err != nil {
+		return err
+	}
+
+	if create, _ := pterm.DefaultInteractiveConfirm.WithDefaultText(fmt.Sprintf("\nCreate %s as a clone of %s?", clone.Alias, profile.Alias)).Show(); !create {
+		fmt.Println()
+		pterm.Info.Println("Profile clone aborted, exiting.")
+		if s.Logger != nil {
+			s.Logger.Log(logger.INFO, "Profile clone aborted by user", "clone", sessionID)
+		}
+		return nil
+	}
+
+	id, err := s.Store.CreateSSHProfile(clone)
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to create profile in database", "clone", sessionID, err)
+		}
+		return err
+	}
+
+	endTime := time.Now()
+	duration := endTime.Sub(startTime)
+
+	if s.Logger != nil {
+		s.Logger.LogWithDetails(logger.INFO, fmt.Sprintf("Successfully cloned profile %s: ID %d - %s", profile.Alias, id, clone.Alias), "clone", sessionID, duration.String(), startTime, endTime, nil)
+	}
+
+	fmt.Println()
+	pterm.Info.Printf("Successfully cloned %s: ID %d - %s\n", profile.Alias, id, clone.Alias)
+	return nil
+}
+
 func (s *ProfileService) DeleteProfile(p string) error {
 	var profileIds []int64
 
 	if !profileIsProvided(p) {
-		if profileIds, _ = s.multiSelectProfiles("Select profiles to delete", 0); len(profileIds) == 0 {
+		if profileIds, _ = s.multiSelectProfiles("Select profiles to delete", 0, true); len(profileIds) == 0 {
 			return fmt.Errorf("no profiles selected, exiting")
 		}
 	} else {
@@ -392,14 +656,177 @@ func (s *ProfileService) DeleteProfile(p string) error {
 		return nil
 	}
 
+	if err := s.backup("delete"); err != nil {
+		return err
+	}
+
+	if err := s.Store.DeleteSSHProfilesById(profileIds); err != nil {
+		return fmt.Errorf("could not delete profiles, nothing was deleted.\n%s", err.Error())
+	}
+
+	fmt.Println()
+	pterm.Info.Printf("Successfully moved %d profile(s) to the trash, use --restore to bring them back.\n", len(profileIds))
+	return nil
+}
+
+func (s *ProfileService) TrashList() error {
+	profiles, err := s.Store.GetDeletedSSHProfiles()
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("the trash is empty")
+	}
+	prettyPrintTrash(profiles, s.TrashRetention)
+	return nil
+}
+
+func (s *ProfileService) RestoreProfile(p string) error {
+	var profileIds []int64
+
+	profiles, err := s.Store.GetDeletedSSHProfiles()
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("the trash is empty")
+	}
+
+	if !profileIsProvided(p) {
+		var options []string
+		for _, profile := range profiles {
+			options = append(options, formatProfileOption(profile))
+		}
+		selectedOptions, _ := pterm.DefaultInteractiveMultiselect.
+			WithDefaultText("Select profiles to restore").
+			WithOptions(options).
+			WithFilter(false).
+			WithKeyConfirm(keys.Enter).
+			WithKeySelect(keys.Space).
+			WithCheckmark(&pterm.Checkmark{Checked: pterm.Green("+"), Unchecked: pterm.Red("-")}).
+			Show()
+		if profileIds, err = parseIdsFromSelectedProfiles(selectedOptions); err != nil {
+			return err
+		}
+		if len(profileIds) == 0 {
+			return fmt.Errorf("no profiles selected, exiting")
+		}
+	} else {
+		// Deleted profiles can't be resolved with parseProfileIdFromArg, so look them up in the trash
+		for _, profile := range profiles {
+			if p == profile.Alias || p == fmt.Sprintf("%d", profile.Id) {
+				profileIds = append(profileIds, profile.Id)
+				break
+			}
+		}
+		if len(profileIds) == 0 {
+			return fmt.Errorf("profile '%s' is not in the trash", p)
+		}
+	}
+
 	for _, id := range profileIds {
-		if err := s.DB.DeleteSSHProfileById(id); err != nil {
-			return fmt.Errorf("could not delete profile.\n%s", err.Error())
+		if err := s.Store.RestoreSSHProfileById(id); err != nil {
+			return fmt.Errorf("could not restore profile.\n%s", err.Error())
+		}
+	}
+
+	fmt.Println()
+	pterm.Info.Printf("Successfully restored %d profile(s).\n", len(profileIds))
+	return nil
+}
+
+func (s *ProfileService) PurgeTrash() error {
+	days := int(s.TrashRetention.Hours() / 24)
+	if d, _ := pterm.DefaultInteractiveConfirm.WithDefaultText(fmt.Sprintf("\nPermanently remove all profiles that are in the trash for more than %d day(s)?", days)).Show(); !d {
+		fmt.Println()
+		pterm.Info.Println("Purge aborted, exiting.")
+		return nil
+	}
+
+	if err := s.backup("purge"); err != nil {
+		return err
+	}
+
+	purged, err := s.Store.PurgeDeletedSSHProfiles(time.Now().Add(-s.TrashRetention))
+	if err != nil {
+		return fmt.Errorf("could not purge the trash.\n%s", err.Error())
+	}
+
+	fmt.Println()
+	pterm.Info.Printf("Permanently removed %d profile(s).\n", purged)
+	return nil
+}
+
+func (s *ProfileService) Revisions(p string) error {
+	if !profileIsProvided(p) {
+		return fmt.Errorf("please provide the profile to show the revisions for")
+	}
+
+	profileId, err := parseProfileIdFromArg(p, s)
+	if err != nil {
+		return err
+	}
+
+	profile, err := s.Store.GetSSHProfileById(profileId)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := s.Store.GetProfileRevisions(profileId)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		return fmt.Errorf("profile '%s' has no revisions", profile.Alias)
+	}
+	prettyPrintRevisions(revisions)
+	return nil
+}
+
+func (s *ProfileService) Rollback(p string, to string) error {
+	if !profileIsProvided(p) {
+		return fmt.Errorf("please provide the profile to roll back")
+	}
+
+	revision, err := strconv.Atoi(to)
+	if err != nil {
+		return fmt.Errorf("please provide the revision to roll back to with --to <rev>")
+	}
+
+	profileId, err := parseProfileIdFromArg(p, s)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := s.Store.GetProfileRevisions(profileId)
+	if err != nil {
+		return err
+	}
+
+	var target *database.ProfileRevision
+	for i := range revisions {
+		if revisions[i].Revision == revision {
+			target = &revisions[i]
+			break
 		}
 	}
+	if target == nil {
+		return fmt.Errorf("revision %d does not exist, use 'sshman revisions %s' to list them", revision, p)
+	}
+
+	prettyPrintRevisions([]database.ProfileRevision{*target})
+	if r, _ := pterm.DefaultInteractiveConfirm.WithDefaultText(fmt.Sprintf("\nRoll back to revision %d?", revision)).Show(); !r {
+		fmt.Println()
+		pterm.Info.Println("Rollback aborted, exiting.")
+		return nil
+	}
+
+	if err = s.Store.RollbackSSHProfile(profileId, revision); err != nil {
+		return fmt.Errorf("could not roll back profile.\n%s", err.Error())
+	}
 
 	fmt.Println()
-	pterm.Info.Printf("Successfully deleted %d profile(s).\n", len(profileIds))
+	pterm.Info.Printf("Successfully rolled back to revision %d, the previous version was saved as a new revision.\n", revision)
 	return nil
 }
 
@@ -418,7 +845,7 @@ func (s *ProfileService) ConnectToServer(p string) error {
 	)
 
 	if !profileIsProvided(p) {
-		if profileId, err = s.selectProfile("Select profile to connect to", 0); err != nil {
+		if profileId, err = s.selectProfile("Select profile to connect to", 0, false); err != nil {
 			if s.Logger != nil {
 				s.Logger.LogError("Failed to select profile", "connect", sessionID, err)
 			}
@@ -433,26 +860,40 @@ func (s *ProfileService) ConnectToServer(p string) error {
 		}
 	}
 
-	if profile, err = s.DB.GetSSHProfileById(profileId); err != nil {
+	if profile, err = s.Store.GetSSHProfileById(profileId); err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to get profile by ID", "connect", sessionID, err)
 		}
 		return err
 	}
+	if profile.IsTemplate {
+		return fmt.Errorf("'%s' is a template, connect to a profile that uses it instead", profile.Alias)
+	}
+	if err = s.resolveProfile(&profile); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to resolve profile template", "connect", sessionID, err)
+		}
+		return err
+	}
+	if err = requireAuth(profile); err != nil {
+		return err
+	}
 
 	if s.Logger != nil {
 		s.Logger.Log(logger.INFO, fmt.Sprintf("Connecting to profile: %s (%s@%s)", profile.Alias, profile.User, profile.Host), "connect", sessionID)
 	}
 
-	if err = decryptProfile(&profile, s.MaskInput, s.DecryptionRetries, s.Logger, sessionID); err != nil {
+	secret, err := s.resolveSecret(profile, sessionID)
+	if err != nil {
 		errMsg := fmt.Sprintf("encountered decryption error %+v", err)
 		if s.Logger != nil {
 			s.Logger.LogError(errMsg, "connect", sessionID, err)
 		}
 		return fmt.Errorf(errMsg)
 	}
+	defer secret.Wipe()
 
-	if err = s.connect(&profile); err != nil {
+	if err = s.connect(&profile, secret); err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to establish SSH connection", "connect", sessionID, err)
 		}
@@ -499,23 +940,43 @@ func (s *ProfileService) ImportProfile(path string) error {
 				return profiles, err
 			}
 
-			var password string
+			var password, agentKey string
 			var pkey []byte
 			if at == database.AuthTypePassword {
 				password = d[5]
+			} else if at == database.AuthTypeAgent {
+				// ssh-agent profiles export the fingerprint of their key
+				agentKey = d[5]
 			} else {
 				pkey = []byte(d[5])
 			}
 
+			// Exports created before port support don't have a port column
+			port := database.DefaultSSHPort
+			if len(d) > 8 {
+				if port, err = strconv.Atoi(d[8]); err != nil {
+					return profiles, fmt.Errorf("invalid port '%s' for profile %s", d[8], d[1])
+				}
+			}
+
+			// Secrets of a secret provider are exported as their reference only
+			var secretRef string
+			if len(d) > 9 {
+				secretRef = d[9]
+			}
+
 			// TODO: this is so whack I need to re-write this
 			profile := database.SSHProfile{
 				Alias:      d[1],
-				Host:       d[2],
-				User:       d[3],
+				User:       d[2],
+				Host:       d[3],
+				Port:       port,
 				Password:   password,
 				PrivateKey: pkey,
 				AuthType:   at,
 				Encrypted:  d[6] == "+",
+				SecretRef:  secretRef,
+				AgentKey:   agentKey,
 				CTime:      date,
 			}
 			profiles = append(profiles, profile)
@@ -523,18 +984,50 @@ func (s *ProfileService) ImportProfile(path string) error {
 		return profiles, nil
 	}
 
+	// Bundles get re-encrypted with the local key while reading them
+	if isBundle(data) {
+		profiles, err := s.readBundle(data)
+		if err != nil {
+			return err
+		}
+		return s.importProfiles(profiles)
+	}
+
 	profiles, err := parseCsv(string(data))
 	if err != nil {
 		return err
 	}
 
-	for _, profile := range profiles {
-		_, err := s.DB.CreateSSHProfile(profile)
+	// Imported profiles keep the key they were exported with, in vault mode they have to use the master password
+	if slices.ContainsFunc(profiles, func(p database.SSHProfile) bool { return p.Encrypted }) {
+		masterKey, err := s.masterKey()
 		if err != nil {
-			log.Printf("Could not create profile %s, error: %s\n", profile, err.Error())
+			return err
+		}
+		if len(masterKey) > 0 {
+			kdf, err := s.keyKDF()
+			if err != nil {
+				return err
+			}
+			if _, err = s.reencryptProfiles(profiles, masterKey, kdf); err != nil {
+				return fmt.Errorf("could not import profiles, nothing was imported.\n%s", err.Error())
+			}
 		}
 	}
+	return s.importProfiles(profiles)
+}
+
+func (s *ProfileService) importProfiles(profiles []database.SSHProfile) error {
+	if err := s.backup("import"); err != nil {
+		return err
+	}
 
+	ids, err := s.Store.CreateSSHProfiles(profiles)
+	if err != nil {
+		return fmt.Errorf("could not import profiles, nothing was imported.\n%s", err.Error())
+	}
+
+	pterm.Success.Printf("Imported %d profile(s).\n", len(ids))
 	return nil
 }
 
@@ -546,6 +1039,45 @@ func (s *ProfileService) ExportProfile(p string) error {
 		s.Logger.Log(logger.INFO, "Starting profile export", "export", sessionID)
 	}
 
+	profiles, err := s.profilesForExport(p, sessionID)
+	if err != nil {
+		return err
+	}
+
+	if p == "decrypt" {
+		if s.Logger != nil {
+			s.Logger.Log(logger.INFO, fmt.Sprintf("Decrypting %d profiles for export", len(profiles)), "export", sessionID)
+		}
+		if err = s.decryptProfiles(profiles, sessionID); err != nil {
+			if s.Logger != nil {
+				s.Logger.LogError("Failed to decrypt profiles for export", "export", sessionID, err)
+			}
+			return fmt.Errorf("encountered decryption error %+v", err)
+		}
+	}
+
+	header := []string{"Id", "Alias", "User", "Host/IP", "Auth Type", "Authentication", "Encrypted", "Created At", "Port", "Secret Reference"}
+	path := fmt.Sprintf("%d.csv", time.Now().Unix())
+	if err = exportProfilesToCSV(path, header, profiles); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to export profiles to CSV", "export", sessionID, err)
+		}
+		return fmt.Errorf("could not export to csv, %s", err.Error())
+	}
+
+	endTime := time.Now()
+	duration := endTime.Sub(startTime)
+
+	if s.Logger != nil {
+		s.Logger.LogWithDetails(logger.INFO, fmt.Sprintf("Successfully exported %d profiles to %s", len(profiles), path), "export", sessionID, duration.String(), startTime, endTime, nil)
+	}
+
+	pterm.Success.Printf("Export created: %s\n", path)
+	return nil
+}
+
+// profilesForExport returns the profile p refers to (or the selected ones) with the values they inherit from their template.
+func (s *ProfileService) profilesForExport(p string, sessionID string) ([]database.SSHProfile, error) {
 	var profileIds []int64
 
 	if profileIsProvided(p) && p != "decrypt" {
@@ -554,77 +1086,85 @@ func (s *ProfileService) ExportProfile(p string) error {
 			if s.Logger != nil {
 				s.Logger.LogError("Failed to parse profile ID for export", "export", sessionID, err)
 			}
-			return err
+			return nil, err
 		}
 		profileIds = append(profileIds, id)
 	} else {
-		if profileIds, _ = s.multiSelectProfiles("Select profiles to export", 0); len(profileIds) == 0 {
+		if profileIds, _ = s.multiSelectProfiles("Select profiles to export", 0, false); len(profileIds) == 0 {
 			err := fmt.Errorf("no profiles selected, exiting")
 			if s.Logger != nil {
 				s.Logger.LogError("No profiles selected for export", "export", sessionID, err)
 			}
-			return err
+			return nil, err
 		}
 	}
 
-	profiles, err := s.DB.GetSSHProfilesById(profileIds)
+	profiles, err := s.Store.GetSSHProfilesById(profileIds)
 	if err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to get profiles by IDs", "export", sessionID, err)
 		}
-		return err
+		return nil, err
 	}
 	if len(profiles) == 0 {
 		err := fmt.Errorf("no profiles found for exporting")
 		if s.Logger != nil {
 			s.Logger.LogError("No profiles found for export", "export", sessionID, err)
 		}
-		return err
-	}
-
-	if p == "decrypt" {
-		if s.Logger != nil {
-			s.Logger.Log(logger.INFO, fmt.Sprintf("Decrypting %d profiles for export", len(profiles)), "export", sessionID)
-		}
-		if err = decryptProfiles(profiles, s.MaskInput, s.DecryptionRetries, s.Logger, sessionID); err != nil {
-			if s.Logger != nil {
-				s.Logger.LogError("Failed to decrypt profiles for export", "export", sessionID, err)
-			}
-			return fmt.Errorf("encountered decryption error %+v", err)
-		}
+		return nil, err
 	}
 
-	header := []string{"Id", "Alias", "User", "Host/IP", "Auth Type", "Authentication", "Encrypted", "Created At"}
-	path := fmt.Sprintf("%d.csv", time.Now().Unix())
-	if err = exportProfilesToCSV(path, header, profiles); err != nil {
+	// Exports don't know about templates, so every profile gets exported with its inherited values
+	if err = s.resolveProfiles(profiles); err != nil {
 		if s.Logger != nil {
-			s.Logger.LogError("Failed to export profiles to CSV", "export", sessionID, err)
+			s.Logger.LogError("Failed to resolve profile templates", "export", sessionID, err)
 		}
-		return fmt.Errorf("could not export to csv, %s", err.Error())
+		return nil, err
 	}
+	return profiles, nil
+}
 
-	endTime := time.Now()
-	duration := endTime.Sub(startTime)
+func (s *ProfileService) ProfilesList() error {
+	var profiles []database.SSHProfileSummary
+	var err error
 
-	if s.Logger != nil {
-		s.Logger.LogWithDetails(logger.INFO, fmt.Sprintf("Successfully exported %d profiles to %s", len(profiles), path), "export", sessionID, duration.String(), startTime, endTime, nil)
+	if profiles, err = s.Store.GetSSHProfileSummaries(); err != nil {
+		return err
+	}
+	templates := templateNames(profiles)
+	if profiles = filterProfilesByTags(resolveSummaries(profiles, false), s.TagFilter); len(profiles) == 0 {
+		return fmt.Errorf("no profiles found")
 	}
 
-	pterm.Success.Printf("Export created: %s\n", path)
+	stats, err := s.Store.GetConnectionStats()
+	if err != nil {
+		return err
+	}
+	prettyPrintProfiles(profiles, stats, templates)
 	return nil
 }
 
-func (s *ProfileService) ProfilesList() error {
-	var profiles []database.SSHProfile
+// Number of sessions shown by the history command
+const historyLimit = 50
+
+func (s *ProfileService) History(p string) error {
+	var profileId int64
 	var err error
 
-	if profiles, err = s.DB.GetAllSSHProfiles(); err != nil || len(profiles) == 0 {
-		if len(profiles) == 0 {
-			return fmt.Errorf("no profiles found")
+	if profileIsProvided(p) {
+		if profileId, err = parseProfileIdFromArg(p, s); err != nil {
+			return err
 		}
+	}
+
+	history, err := s.Store.GetConnectionHistory(profileId, historyLimit)
+	if err != nil {
 		return err
 	}
-	prettyPrintProfiles(profiles)
+	if len(history) == 0 {
+		return fmt.Errorf("no connection history found")
+	}
+	prettyPrintHistory(history)
 	return nil
 }
 
@@ -691,36 +1231,67 @@ func (s *ProfileService) SCPFile(from, to string) error {
 		return fmt.Errorf(errMsg)
 	}
 
-	if profile, err = s.DB.GetSSHProfileById(profileId); err != nil {
+	if profile, err = s.Store.GetSSHProfileById(profileId); err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError("Failed to get profile by ID", "scp", sessionID, err)
 		}
 		return err
 	}
+	if profile.IsTemplate {
+		return fmt.Errorf("'%s' is a template, use a profile that inherits from it instead", profile.Alias)
+	}
+	if err = s.resolveProfile(&profile); err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to resolve profile template", "scp", sessionID, err)
+		}
+		return err
+	}
+	if err = requireAuth(profile); err != nil {
+		return err
+	}
 
 	if s.Logger != nil {
 		s.Logger.Log(logger.INFO, fmt.Sprintf("Resolved profile: %s (%s@%s)", profile.Alias, profile.User, profile.Host), "scp", sessionID)
 	}
 
-	if err = decryptProfile(&profile, s.MaskInput, s.DecryptionRetries, s.Logger, sessionID); err != nil {
+	secret, err := s.resolveSecret(profile, sessionID)
+	if err != nil {
 		errMsg := fmt.Sprintf("encountered decryption error: %v", err)
 		if s.Logger != nil {
 			s.Logger.LogError(errMsg, "scp", sessionID, err)
 		}
 		return fmt.Errorf(errMsg)
 	}
+	defer secret.Wipe()
 
 	// Establish SSH connection for SCP (without interactive shell)
 	server := ssh.SSHServer{
-		User: profile.User,
-		Host: profile.Host,
-		SecureConnection: false,
-		Logger: s.Logger,
-		SessionID: sessionID,
+		User:            profile.User,
+		Host:            profile.Host,
+		Port:            uint(profile.Port),
+		HostKeyCallback: s.hostKeyCallback(profile, sessionID),
+		Logger:          s.Logger,
+		SessionID:       sessionID,
 	}
 
-	if profile.AuthType == database.AuthTypePrivateKey {
-		if err = server.ConnectSSHServerWithPrivateKey(profile.PrivateKey); err != nil {
+	if profile.AuthType == database.AuthTypeAgent {
+		if err = server.ConnectSSHServerWithAgent(profile.AgentKey); err != nil {
+			errMsg := fmt.Sprintf("failed to connect to server: %v", err)
+			if s.Logger != nil {
+				s.Logger.LogError(errMsg, "scp", sessionID, err)
+			}
+			return fmt.Errorf(errMsg)
+		}
+	} else if profile.AuthType == database.AuthTypeKeyboard {
+		if err = server.ConnectSSHServerWithKeyboardInteractive(); err != nil {
+			errMsg := fmt.Sprintf("failed to connect to server: %v", err)
+			if s.Logger != nil {
+				s.Logger.LogError(errMsg, "scp", sessionID, err)
+			}
+			return fmt.Errorf(errMsg)
+		}
+	} else if profile.AuthType == database.AuthTypePrivateKey {
+		if err = server.ConnectSSHServerWithPrivateKey(secret); err != nil {
 			errMsg := fmt.Sprintf("failed to connect to server: %v", err)
 			if s.Logger != nil {
 				s.Logger.LogError(errMsg, "scp", sessionID, err)
@@ -728,7 +1299,7 @@ func (s *ProfileService) SCPFile(from, to string) error {
 			return fmt.Errorf(errMsg)
 		}
 	} else {
-		if err = server.ConnectSSHServerWithPassword(profile.Password); err != nil {
+		if err = server.ConnectSSHServerWithPassword(secret); err != nil {
 			errMsg := fmt.Sprintf("failed to connect to server: %v", err)
 			if s.Logger != nil {
 				s.Logger.LogError(errMsg, "scp", sessionID, err)
@@ -791,4 +1362,3 @@ func (s *ProfileService) SCPFile(from, to string) error {
 	pterm.Success.Println("File transfer completed successfully!")
 	return nil
 }
-
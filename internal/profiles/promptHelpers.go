@@ -0,0 +1,118 @@
+package profiles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mikeunge/sshman/internal/database"
+
+	"github.com/pterm/pterm"
+)
+
+// profilePrompts asks for the settings shared by new, updated and cloned profiles.
+// Profiles of a template may leave every input empty to inherit the value of the template.
+type profilePrompts struct {
+	service   *ProfileService
+	writer    *pterm.InteractiveTextInputPrinter
+	template  *database.SSHProfile // nil if the profile doesn't inherit from a template
+	operation string               // used for logging, e.g. "new"
+	sessionID string
+}
+
+func (s *ProfileService) newProfilePrompts(template *database.SSHProfile, operation string, sessionID string) *profilePrompts {
+	return &profilePrompts{
+		service:   s,
+		writer:    pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)),
+		template:  template,
+		operation: operation,
+		sessionID: sessionID,
+	}
+}
+
+// ask shows the input prefilled with the current value, failures are logged with the message.
+func (p *profilePrompts) ask(text string, current string, verify validator, message string) (string, error) {
+	value, err := parseAndVerifyInput(p.writer.WithDefaultText(text).WithDefaultValue(current), verify)
+	if err != nil && p.service.Logger != nil {
+		p.service.Logger.LogError(message, p.operation, p.sessionID, err)
+	}
+	return value, err
+}
+
+func (p *profilePrompts) user(current string) (string, error) {
+	if p.template != nil {
+		return p.ask(inheritedText("User", p.template.User), current, optional(validateUser), "Failed to parse user input")
+	}
+	return p.ask("User", current, validateUser, "Failed to parse user input")
+}
+
+// host is never inherited, templates don't have one.
+func (p *profilePrompts) host(current string) (string, error) {
+	return p.ask("Host", current, validateHost, "Failed to parse host input")
+}
+
+// port returns 0 if a profile of a template inherits the port, new profiles without a template default to port 22.
+func (p *profilePrompts) port(current int) (int, error) {
+	var port string
+	var err error
+
+	if p.template != nil {
+		currentPort := ""
+		if current != 0 {
+			currentPort = fmt.Sprintf("%d", current)
+		}
+		port, err = p.ask(inheritedText("Port", fmt.Sprintf("%d", p.template.Port)), currentPort, optional(validatePort), "Failed to parse port input")
+	} else {
+		if current == 0 {
+			current = database.DefaultSSHPort
+		}
+		port, err = p.ask("Port", fmt.Sprintf("%d", current), validatePort, "Failed to parse port input")
+	}
+	if err != nil {
+		return 0, err
+	}
+	value, _ := strconv.Atoi(port)
+	return value, nil
+}
+
+// startupCommand returns database.NoStartupCommand if a profile of a template shouldn't run the startup command of the template.
+func (p *profilePrompts) startupCommand(current string) (string, error) {
+	text := "Startup Command (optional, press Enter to skip)"
+	if p.template != nil && len(p.template.StartupCommand) > 0 {
+		text = fmt.Sprintf("Startup Command (press Enter to inherit '%s', '%s' for none)", p.template.StartupCommand, database.NoStartupCommand)
+	} else if p.template != nil {
+		text = inheritedText("Startup Command", "")
+	} else if len(current) > 0 {
+		text = "Startup Command (press Enter to keep current)"
+	}
+	cmd, err := p.ask(text, current, func(cmd string) (string, error) {
+		// Allow empty commands
+		return cmd, nil
+	}, "Failed to parse startup command")
+
+	// Without a template there is nothing to override
+	if p.template == nil && cmd == database.NoStartupCommand {
+		cmd = ""
+	}
+	return cmd, err
+}
+
+func (p *profilePrompts) tags(current []string) ([]string, error) {
+	tags, err := p.ask("Tags (optional, comma separated)", strings.Join(current, ", "), validateTags, "Failed to parse tags")
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(tags), nil
+}
+
+func (p *profilePrompts) env(current map[string]string) (map[string]string, error) {
+	text := "Environment (optional, comma separated NAME=value, \\, for a comma in a value)"
+	if p.template != nil && len(p.template.Env) > 0 {
+		text = fmt.Sprintf("Environment (comma separated NAME=value, \\, for a comma in a value, inherits %s)", formatEnv(p.template.Env))
+	}
+	env, err := p.ask(text, formatEnv(current), validateEnv, "Failed to parse environment variables")
+	if err != nil {
+		return nil, err
+	}
+	return parseEnv(env), nil
+}
@@ -0,0 +1,87 @@
+package profiles
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/internal/secrets"
+	"github.com/mikeunge/sshman/pkg/helpers"
+	"github.com/mikeunge/sshman/pkg/logger"
+
+	"github.com/pterm/pterm"
+)
+
+// Option for secrets that are stored (and encrypted) by sshman itself
+const storedSecret = "Stored by sshman"
+
+// resolveSecret returns the plain secret of the profile, either from its secret provider or by decrypting the stored one.
+// The caller wipes the secret once it's used.
+func (s *ProfileService) resolveSecret(profile database.SSHProfile, sessionID string) (helpers.Secret, error) {
+	if len(profile.SecretRef) == 0 {
+		return s.decryptProfile(profile, sessionID)
+	}
+
+	name, ref, err := secrets.ParseRef(profile.SecretRef)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := s.SecretProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("secret provider '%s' of profile %s is not configured", name, profile.Alias)
+	}
+
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, fmt.Sprintf("Resolving secret of profile %s with provider %s", profile.Alias, name), "secret", sessionID)
+	}
+	output, err := provider.Secret(ref)
+	if err != nil {
+		return nil, err
+	}
+	secret := helpers.Secret(output)
+
+	if profile.AuthType == database.AuthTypePassword {
+		// Password stores like pass keep the password in the first line, followed by optional metadata
+		if i := bytes.IndexByte(secret, '\n'); i >= 0 {
+			clear(secret[i:])
+			secret = secret[:i]
+		}
+		if i := len(secret) - 1; i >= 0 && secret[i] == '\r' {
+			secret = secret[:i]
+		}
+	}
+	return secret, nil
+}
+
+// selectSecretRef asks where the secret of a profile is kept, it is empty if sshman stores the secret.
+func (s *ProfileService) selectSecretRef() (string, error) {
+	if len(s.SecretProviders) == 0 {
+		return "", nil
+	}
+
+	var names []string
+	for name := range s.SecretProviders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	selected, _ := pterm.DefaultInteractiveSelect.WithDefaultText("Where is the secret kept?").WithOptions(append([]string{storedSecret}, names...)).Show()
+	if selected == storedSecret {
+		return "", nil
+	}
+	return s.askSecretRef(selected, "")
+}
+
+// askSecretRef asks for the reference the provider resolves, e.g. the path of a pass entry.
+func (s *ProfileService) askSecretRef(provider string, current string) (string, error) {
+	ref, _ := pterm.DefaultInteractiveTextInput.
+		WithTextStyle(pterm.NewStyle(pterm.FgDefault)).
+		WithDefaultText(fmt.Sprintf("Secret reference (%s)", provider)).
+		WithDefaultValue(current).
+		Show()
+	if len(ref) == 0 {
+		return "", fmt.Errorf("the secret reference cannot be empty")
+	}
+	return secrets.FormatRef(provider, ref), nil
+}
@@ -0,0 +1,56 @@
+package profiles
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mikeunge/sshman/pkg/ssh"
+
+	"github.com/pterm/pterm"
+)
+
+// Option for agent profiles that aren't pinned to a key
+const anyAgentKey = "Any key of the ssh-agent"
+
+// selectAgentKey asks which key of the ssh-agent a profile uses, it is empty if the server gets offered every key.
+// If the agent can't be reached the fingerprint can be entered instead.
+func (s *ProfileService) selectAgentKey(current string) (string, error) {
+	keys, err := ssh.AgentKeys()
+	if err != nil || len(keys) == 0 {
+		if err == nil {
+			err = fmt.Errorf("the ssh-agent doesn't hold any keys")
+		}
+		pterm.Warning.Printf("Could not list the keys of the ssh-agent, %s\n", err.Error())
+
+		fingerprint, _ := pterm.DefaultInteractiveTextInput.
+			WithTextStyle(pterm.NewStyle(pterm.FgDefault)).
+			WithDefaultText("Key fingerprint (optional, press Enter to use any key)").
+			WithDefaultValue(current).
+			Show()
+		return normalizeFingerprint(fingerprint), nil
+	}
+
+	options := []string{anyAgentKey}
+	for _, key := range keys {
+		options = append(options, fmt.Sprintf("%s %s (%s)", key.Fingerprint, key.Comment, key.Type))
+	}
+	selectInput := pterm.DefaultInteractiveSelect.WithDefaultText("Which key of the ssh-agent should be used?").WithOptions(options)
+	if i := slices.IndexFunc(keys, func(k ssh.AgentKey) bool { return k.Fingerprint == current }); i >= 0 {
+		selectInput = selectInput.WithDefaultOption(options[i+1])
+	}
+	selected, _ := selectInput.Show()
+	if selected == anyAgentKey {
+		return "", nil
+	}
+	return strings.Fields(selected)[0], nil
+}
+
+// normalizeFingerprint adds the hash prefix ssh-add -l prints, so both forms can be entered.
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimSpace(fingerprint)
+	if len(fingerprint) == 0 || strings.HasPrefix(fingerprint, "SHA256:") {
+		return fingerprint
+	}
+	return "SHA256:" + fingerprint
+}
@@ -0,0 +1,202 @@
+package profiles
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/mikeunge/sshman/internal/database"
+
+	"github.com/pterm/pterm"
+)
+
+// resolveProfile fills the fields a profile inherits from its template.
+// Profiles only store the fields they override, so changes to a template apply to all of its profiles.
+func (s *ProfileService) resolveProfile(profile *database.SSHProfile) error {
+	if profile.TemplateId == 0 {
+		return nil
+	}
+
+	template, err := s.Store.GetSSHProfileById(profile.TemplateId)
+	if err != nil {
+		return fmt.Errorf("could not load the template of profile %s, %s", profile.Alias, err.Error())
+	}
+	applyTemplate(profile, template)
+	return nil
+}
+
+func (s *ProfileService) resolveProfiles(profiles []database.SSHProfile) error {
+	for i := range profiles {
+		if err := s.resolveProfile(&profiles[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyTemplate sets every field the profile doesn't override, environment variables are merged.
+func applyTemplate(profile *database.SSHProfile, template database.SSHProfile) {
+	if len(profile.User) == 0 {
+		profile.User = template.User
+	}
+	if profile.Port == 0 {
+		profile.Port = template.Port
+	}
+	switch profile.StartupCommand {
+	case "":
+		profile.StartupCommand = template.StartupCommand
+	case database.NoStartupCommand:
+		profile.StartupCommand = ""
+	}
+	if !profile.HasAuth() {
+		profile.AuthType = template.AuthType
+		profile.Password = template.Password
+		profile.PrivateKey = template.PrivateKey
+		profile.Encrypted = template.Encrypted
+		profile.KeyCheck = template.KeyCheck
+		profile.SecretRef = template.SecretRef
+		profile.AgentKey = template.AgentKey
+	}
+
+	env := maps.Clone(template.Env)
+	if env == nil {
+		env = make(map[string]string)
+	}
+	maps.Copy(env, profile.Env)
+	if len(env) > 0 {
+		profile.Env = env
+	}
+}
+
+// requireAuth makes sure a profile can authenticate, profiles of a template without authentication have to set their own.
+func requireAuth(profile database.SSHProfile) error {
+	if profile.HasAuth() {
+		return nil
+	}
+	return fmt.Errorf("%s doesn't have an authentication and its template doesn't set one either, add one with 'sshman --update'", profile.Alias)
+}
+
+// resolveSummaries applies the templates to the summaries, templates are only kept if includeTemplates is set.
+func resolveSummaries(summaries []database.SSHProfileSummary, includeTemplates bool) []database.SSHProfileSummary {
+	var resolved []database.SSHProfileSummary
+
+	templates := make(map[int64]database.SSHProfileSummary)
+	for _, summary := range summaries {
+		if summary.IsTemplate {
+			templates[summary.Id] = summary
+		}
+	}
+
+	for _, summary := range summaries {
+		if summary.IsTemplate && !includeTemplates {
+			continue
+		}
+		if template, ok := templates[summary.TemplateId]; ok {
+			if len(summary.User) == 0 {
+				summary.User = template.User
+			}
+			if summary.Port == 0 {
+				summary.Port = template.Port
+			}
+			if !summary.HasAuth {
+				summary.AuthType = template.AuthType
+				summary.Encrypted = template.Encrypted
+				summary.HasAuth = template.HasAuth
+			}
+		}
+		resolved = append(resolved, summary)
+	}
+	return resolved
+}
+
+// templateNames maps the template ids to their alias.
+func templateNames(summaries []database.SSHProfileSummary) map[int64]string {
+	names := make(map[int64]string)
+	for _, summary := range summaries {
+		if summary.IsTemplate {
+			names[summary.Id] = summary.Alias
+		}
+	}
+	return names
+}
+
+// selectTemplate lets the user pick the template of a new profile, it returns nil if there are no templates or none was picked.
+func (s *ProfileService) selectTemplate() (*database.SSHProfile, error) {
+	summaries, err := s.Store.GetSSHProfileSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	options := []string{"None"}
+	for _, summary := range summaries {
+		if summary.IsTemplate {
+			options = append(options, formatProfileOption(summary))
+		}
+	}
+	if len(options) == 1 {
+		return nil, nil
+	}
+
+	selectedOption, _ := pterm.DefaultInteractiveSelect.WithDefaultText("Inherit from template").WithOptions(options).Show()
+	if selectedOption == options[0] {
+		return nil, nil
+	}
+
+	ids, err := parseIdsFromSelectedProfiles([]string{selectedOption})
+	if err != nil || len(ids) == 0 {
+		return nil, fmt.Errorf("could not parse template id")
+	}
+	template, err := s.Store.GetSSHProfileById(ids[0])
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (s *ProfileService) NewTemplate(skipEncryption bool) error {
+	return s.createProfile(skipEncryption, true)
+}
+
+func (s *ProfileService) TemplatesList() error {
+	summaries, err := s.Store.GetSSHProfileSummaries()
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	children := make(map[int64]int)
+	for _, summary := range summaries {
+		if summary.IsTemplate {
+			ids = append(ids, summary.Id)
+		}
+		children[summary.TemplateId]++
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no templates found, create one with 'sshman template new'")
+	}
+
+	templates, err := s.Store.GetSSHProfilesById(ids)
+	if err != nil {
+		return err
+	}
+	prettyPrintTemplates(templates, children)
+	return nil
+}
+
+// inheritedText describes the value a profile inherits if the input is left empty.
+func inheritedText(text string, value string) string {
+	if len(strings.TrimSpace(value)) == 0 {
+		return fmt.Sprintf("%s (press Enter to inherit)", text)
+	}
+	return fmt.Sprintf("%s (press Enter to inherit '%s')", text, value)
+}
+
+// optional allows an empty input, everything else has to pass the validator.
+func optional(verify validator) validator {
+	return func(t string) (string, error) {
+		if len(t) == 0 {
+			return t, nil
+		}
+		return verify(t)
+	}
+}
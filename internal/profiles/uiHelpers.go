@@ -2,6 +2,8 @@ package profiles
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/mikeunge/sshman/internal/database"
 
@@ -9,19 +11,21 @@ import (
 	"github.com/pterm/pterm"
 )
 
-func (s *ProfileService) multiSelectProfiles(t string, maxHeight int) ([]int64, error) {
-	var profiles []database.SSHProfile
+func (s *ProfileService) multiSelectProfiles(t string, maxHeight int, includeTemplates bool) ([]int64, error) {
+	var profiles []database.SSHProfileSummary
 	var selectedProfiles []int64
 	var err error
 
-	if profiles, err = s.DB.GetAllSSHProfiles(); err != nil {
+	if profiles, err = s.Store.GetSSHProfileSummaries(); err != nil {
 		return selectedProfiles, err
 	}
+	if profiles = filterProfilesByTags(resolveSummaries(profiles, includeTemplates), s.TagFilter); len(profiles) == 0 {
+		return selectedProfiles, fmt.Errorf("no profiles found")
+	}
 
 	var pProfiles []string
 	for _, p := range profiles {
-		authType := database.GetNameFromAuthType(p.AuthType)
-		pProfiles = append(pProfiles, fmt.Sprintf("%d %s %s@%s (%s)", p.Id, p.Alias, p.User, p.Host, authType))
+		pProfiles = append(pProfiles, formatProfileOption(p))
 	}
 
 	height := len(pProfiles)
@@ -45,18 +49,20 @@ func (s *ProfileService) multiSelectProfiles(t string, maxHeight int) ([]int64,
 	return selectedProfiles, nil
 }
 
-func (s *ProfileService) selectProfile(t string, maxHeight int) (int64, error) {
-	var profiles []database.SSHProfile
+func (s *ProfileService) selectProfile(t string, maxHeight int, includeTemplates bool) (int64, error) {
+	var profiles []database.SSHProfileSummary
 	var err error
 
-	if profiles, err = s.DB.GetAllSSHProfiles(); err != nil {
+	if profiles, err = s.Store.GetSSHProfileSummaries(); err != nil {
 		return 0, err
 	}
+	if profiles = filterProfilesByTags(resolveSummaries(profiles, includeTemplates), s.TagFilter); len(profiles) == 0 {
+		return 0, fmt.Errorf("no profiles found")
+	}
 
 	var pProfiles []string
 	for _, p := range profiles {
-		authType := database.GetNameFromAuthType(p.AuthType)
-		pProfiles = append(pProfiles, fmt.Sprintf("%d %s %s@%s (%s)", p.Id, p.Alias, p.User, p.Host, authType))
+		pProfiles = append(pProfiles, formatProfileOption(p))
 	}
 
 	height := len(pProfiles)
@@ -85,18 +91,116 @@ func (s *ProfileService) selectProfile(t string, maxHeight int) (int64, error) {
 	return parsedProfileIds[0], nil
 }
 
-func prettyPrintProfiles(profiles []database.SSHProfile) {
+// formatProfileOption renders a profile for the selectors, the id always has to come first.
+func formatProfileOption(p database.SSHProfileSummary) string {
+	authType := database.GetNameFromAuthType(p.AuthType)
+	option := fmt.Sprintf("%d %s %s@%s (%s)", p.Id, p.Alias, p.User, p.Host, authType)
+	if p.IsTemplate {
+		option = fmt.Sprintf("%d %s (template, %s)", p.Id, p.Alias, authType)
+	}
+	if len(p.Tags) > 0 {
+		option = fmt.Sprintf("%s [%s]", option, strings.Join(p.Tags, ", "))
+	}
+	return option
+}
+
+func prettyPrintProfiles(profiles []database.SSHProfileSummary, stats map[int64]database.ConnectionStats, templates map[int64]string) {
 	var data [][]string
 	var dFormat = "02.01.2006"
 
-	data = append(data, []string{"Id", "Alias", "User", "Host/IP", "Authentication", "Encrypted", "Created At"}) // define the table header
+	data = append(data, []string{"Id", "Alias", "User", "Host/IP", "Port", "Authentication", "Encrypted", "Tags", "Template", "Last Connected", "Connections", "Created At"}) // define the table header
 	for _, profile := range profiles {
 		encrypted := "-"
 		authType := database.GetNameFromAuthType(profile.AuthType)
 		if profile.Encrypted {
 			encrypted = "+"
 		}
-		data = append(data, []string{fmt.Sprintf("%d", profile.Id), profile.Alias, profile.User, profile.Host, authType, encrypted, profile.CTime.Format(dFormat)})
+		template := "-"
+		if profile.TemplateId != 0 {
+			template = templates[profile.TemplateId]
+		}
+		lastConnected := "-"
+		stat := stats[profile.Id]
+		if stat.Count > 0 {
+			lastConnected = stat.LastConnected.Local().Format("02.01.2006 15:04")
+		}
+		data = append(data, []string{fmt.Sprintf("%d", profile.Id), profile.Alias, profile.User, profile.Host, fmt.Sprintf("%d", profile.Port), authType, encrypted, strings.Join(profile.Tags, ", "), template, lastConnected, fmt.Sprintf("%d", stat.Count), profile.CTime.Format(dFormat)})
+	}
+	pterm.DefaultTable.
+		WithHasHeader().
+		WithData(data).
+		Render()
+}
+
+func prettyPrintTemplates(templates []database.SSHProfile, children map[int64]int) {
+	var data [][]string
+
+	data = append(data, []string{"Id", "Name", "User", "Port", "Authentication", "Encrypted", "Startup Command", "Environment", "Profiles"}) // define the table header
+	for _, template := range templates {
+		authType := database.GetNameFromAuthType(template.AuthType)
+		if !template.HasAuth() {
+			authType = "-"
+		}
+		encrypted := "-"
+		if template.Encrypted {
+			encrypted = "+"
+		}
+		data = append(data, []string{fmt.Sprintf("%d", template.Id), template.Alias, template.User, fmt.Sprintf("%d", template.Port), authType, encrypted, template.StartupCommand, formatEnv(template.Env), fmt.Sprintf("%d", children[template.Id])})
+	}
+	pterm.DefaultTable.
+		WithHasHeader().
+		WithData(data).
+		Render()
+}
+
+func prettyPrintHistory(history []database.ConnectionHistory) {
+	var data [][]string
+	var dFormat = "02.01.2006 15:04:05"
+
+	data = append(data, []string{"Alias", "Start", "End", "Duration", "Exit Status", "Error"}) // define the table header
+	for _, entry := range history {
+		exitStatus := fmt.Sprintf("%d", entry.ExitStatus)
+		if entry.ExitStatus < 0 {
+			exitStatus = "-"
+		}
+		data = append(data, []string{entry.Alias, entry.Start.Local().Format(dFormat), entry.End.Local().Format(dFormat), formatDuration(entry.Duration), exitStatus, entry.Error})
+	}
+	pterm.DefaultTable.
+		WithHasHeader().
+		WithData(data).
+		Render()
+}
+
+func prettyPrintTrash(profiles []database.SSHProfileSummary, retention time.Duration) {
+	var data [][]string
+	var dFormat = "02.01.2006 15:04"
+
+	data = append(data, []string{"Id", "Alias", "User", "Host/IP", "Tags", "Deleted At", "Purgeable From"}) // define the table header
+	for _, profile := range profiles {
+		deletedAt := profile.DeletedAt.Local()
+		data = append(data, []string{fmt.Sprintf("%d", profile.Id), profile.Alias, profile.User, profile.Host, strings.Join(profile.Tags, ", "), deletedAt.Format(dFormat), deletedAt.Add(retention).Format(dFormat)})
+	}
+	pterm.DefaultTable.
+		WithHasHeader().
+		WithData(data).
+		Render()
+}
+
+func prettyPrintRevisions(revisions []database.ProfileRevision) {
+	var data [][]string
+	var dFormat = "02.01.2006 15:04"
+
+	row := func(revision string, date string, p database.SSHProfile) []string {
+		encrypted := "-"
+		if p.Encrypted {
+			encrypted = "+"
+		}
+		return []string{revision, date, p.Alias, p.User, p.Host, fmt.Sprintf("%d", p.Port), database.GetNameFromAuthType(p.AuthType), encrypted, strings.Join(p.Tags, ", "), formatEnv(p.Env), p.StartupCommand}
+	}
+
+	data = append(data, []string{"Revision", "Replaced At", "Alias", "User", "Host/IP", "Port", "Authentication", "Encrypted", "Tags", "Environment", "Startup Command"}) // define the table header
+	for _, revision := range revisions {
+		data = append(data, row(fmt.Sprintf("%d", revision.Revision), revision.CTime.Local().Format(dFormat), revision.Profile))
 	}
 	pterm.DefaultTable.
 		WithHasHeader().
@@ -4,18 +4,55 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/internal/secrets"
 	"github.com/mikeunge/sshman/pkg/helpers"
 
 	input_autocomplete "github.com/JoaoDanielRufino/go-input-autocomplete"
 	"github.com/pterm/pterm"
 )
 
-// updateAuth handles updating authentication data (password or private key) for a profile
-func (s *ProfileService) updateAuth(originalProfile database.SSHProfile, updatedProfile *database.SSHProfile, updatedEntries *uint8, originalEncryptedPassword string, originalEncryptedPrivateKey []byte, originalEncryptedFlag bool) error {
+// updateAuth handles updating authentication data (password or private key) for a profile.
+// originalKey is the key that already decrypted the profile, keyOwner is the profile storing the secret (the template if it's inherited).
+func (s *ProfileService) updateAuth(originalProfile database.SSHProfile, updatedProfile *database.SSHProfile, updatedEntries *uint8, originalEncryptedPassword string, originalEncryptedPrivateKey []byte, originalEncryptedFlag bool, originalKey string, keyOwner database.SSHProfile) error {
 	var auth string
 
+	// Secrets of a secret provider are never stored, only the reference can change
+	if len(originalProfile.SecretRef) > 0 {
+		name, ref, err := secrets.ParseRef(originalProfile.SecretRef)
+		if err != nil {
+			return err
+		}
+		if updatedProfile.SecretRef, err = s.askSecretRef(name, ref); err != nil {
+			return err
+		}
+		if updatedProfile.SecretRef != originalProfile.SecretRef {
+			*updatedEntries++
+		}
+		return nil
+	}
+
+	// The ssh-agent keeps the key, only the pinned fingerprint can change
+	if originalProfile.AuthType == database.AuthTypeAgent {
+		var err error
+		if updatedProfile.AgentKey, err = s.selectAgentKey(originalProfile.AgentKey); err != nil {
+			return err
+		}
+		if updatedProfile.AgentKey != originalProfile.AgentKey {
+			*updatedEntries++
+		}
+		return nil
+	}
+	// The prompts of keyboard-interactive are answered when connecting
+	if originalProfile.AuthType == database.AuthTypeKeyboard {
+		return nil
+	}
+
 	if originalProfile.AuthType == database.AuthTypePassword {
 		pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("%s\n", "Press enter to keep the original password.")
 		input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)).WithDefaultText("Password")
@@ -28,18 +65,24 @@ func (s *ProfileService) updateAuth(originalProfile database.SSHProfile, updated
 			// Keep original encrypted password and encrypted flag
 			updatedProfile.Password = originalEncryptedPassword
 			updatedProfile.Encrypted = originalEncryptedFlag
+			updatedProfile.KeyCheck = originalProfile.KeyCheck
 		} else {
 			// User entered a new password
 			if originalEncryptedFlag {
 				// Need to encrypt the new password with a key
-				newEncKey, err := s.getEncryptionKeyForUpdate()
+				newEncKey, err := s.getEncryptionKeyForUpdate(originalKey, keyOwner)
+				if err != nil {
+					return err
+				}
+				kdf, err := s.keyKDF()
 				if err != nil {
 					return err
 				}
-				if auth, err = helpers.EncryptString(auth, newEncKey); err != nil {
+				if auth, err = helpers.EncryptString([]byte(auth), newEncKey, kdf); err != nil {
 					return err
 				}
 				updatedProfile.Encrypted = true
+				updatedProfile.KeyCheck = helpers.KeyCheck(newEncKey)
 			} else {
 				// Original wasn't encrypted, so new one won't be either
 				updatedProfile.Encrypted = false
@@ -59,6 +102,7 @@ func (s *ProfileService) updateAuth(originalProfile database.SSHProfile, updated
 			// Keep original private key and encrypted flag
 			updatedProfile.PrivateKey = originalEncryptedPrivateKey
 			updatedProfile.Encrypted = originalEncryptedFlag
+			updatedProfile.KeyCheck = originalProfile.KeyCheck
 		} else {
 			// User wants to update the key file
 			if !helpers.FileExists(helpers.SanitizePath(auth)) {
@@ -71,16 +115,22 @@ func (s *ProfileService) updateAuth(originalProfile database.SSHProfile, updated
 
 			if originalEncryptedFlag {
 				// Need to encrypt the new key with a key
-				newEncKey, err := s.getEncryptionKeyForUpdate()
+				newEncKey, err := s.getEncryptionKeyForUpdate(originalKey, keyOwner)
 				if err != nil {
 					return err
 				}
-				if encData, err := helpers.EncryptString(string(data), newEncKey); err != nil {
+				kdf, err := s.keyKDF()
+				if err != nil {
+					return err
+				}
+				if encData, err := helpers.EncryptString(data, newEncKey, kdf); err != nil {
 					return err
 				} else {
+					clear(data)
 					data = []byte(encData)
 				}
 				updatedProfile.Encrypted = true
+				updatedProfile.KeyCheck = helpers.KeyCheck(newEncKey)
 			} else {
 				// Original wasn't encrypted, so new one won't be either
 				updatedProfile.Encrypted = false
@@ -94,7 +144,12 @@ func (s *ProfileService) updateAuth(originalProfile database.SSHProfile, updated
 }
 
 // getEncryptionKeyForUpdate gets the encryption key to use when updating an encrypted profile
-func (s *ProfileService) getEncryptionKeyForUpdate() (string, error) {
+func (s *ProfileService) getEncryptionKeyForUpdate(originalKey string, keyOwner database.SSHProfile) (string, error) {
+	// In vault mode the key can only be changed for all profiles at once
+	if masterKey, err := s.masterKey(); err != nil || len(masterKey) > 0 {
+		return masterKey, err
+	}
+
 	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("%s\n", "Press enter to keep the original encryption key.")
 	input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)).WithDefaultText("(New) Encryption key")
 	if s.MaskInput {
@@ -103,20 +158,30 @@ func (s *ProfileService) getEncryptionKeyForUpdate() (string, error) {
 
 	encKey, _ := input.Show()
 	if len(encKey) == 0 {
-		// User wants to keep the original encryption key - we need to ask for it
-		originalEncKey, err := s.getOriginalEncryptionKey()
-		if err != nil {
-			return "", err
+		// The key that decrypted the profile is already verified, only an inherited secret wasn't decrypted yet
+		if len(originalKey) > 0 {
+			return originalKey, nil
 		}
-		return originalEncKey, nil
+		return s.getOriginalEncryptionKey(keyOwner)
+	}
+
+	// A typo would lock the profile forever, so a new key has to be entered twice
+	if repeated, _ := input.WithDefaultText("(New) Encryption key (repeat)").Show(); repeated != encKey {
+		return "", fmt.Errorf("the encryption keys don't match")
+	}
+
+	// The old key can't decrypt the profile anymore, so keep a backup in case the new one gets lost
+	if err := s.backup("rekey"); err != nil {
+		return "", err
 	}
 
 	// Use the new encryption key provided
 	return helpers.CreateHash(encKey), nil
 }
 
-// getOriginalEncryptionKey prompts the user for the original encryption key
-func (s *ProfileService) getOriginalEncryptionKey() (string, error) {
+// getOriginalEncryptionKey prompts the user for the original encryption key of the profile storing the secret.
+// The key is checked against the verifier, profiles without one are checked by decrypting their secret.
+func (s *ProfileService) getOriginalEncryptionKey(keyOwner database.SSHProfile) (string, error) {
 	input := pterm.DefaultInteractiveTextInput.
 		WithTextStyle(pterm.NewStyle(pterm.FgDefault)).
 		WithDefaultText("Original Decryption Key")
@@ -125,7 +190,13 @@ func (s *ProfileService) getOriginalEncryptionKey() (string, error) {
 	}
 
 	encKey, _ := input.Show()
-	return helpers.CreateHash(encKey), nil
+	hash := helpers.CreateHash(encKey)
+	secret, err := decryptSecret(keyOwner, hash)
+	if err != nil {
+		return "", errWrongKey
+	}
+	secret.Wipe()
+	return hash, nil
 }
 
 // Validation functions to eliminate duplication
@@ -145,6 +216,14 @@ func validateHost(host string) (string, error) {
 	return host, nil
 }
 
+func validatePort(port string) (string, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return port, fmt.Errorf("port must be a number between 1 and 65535")
+	}
+	return port, nil
+}
+
 func validateAlias(alias string) (string, error) {
 	if len(alias) == 0 {
 		return alias, fmt.Errorf("alias cannot be empty")
@@ -154,6 +233,36 @@ func validateAlias(alias string) (string, error) {
 	return alias, nil
 }
 
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]*$`)
+
+func validateTags(tags string) (string, error) {
+	for _, tag := range parseTags(tags) {
+		if len(tag) > 50 {
+			return tags, fmt.Errorf("tag '%s' is too long, 50 characters max", tag)
+		} else if !tagPattern.MatchString(tag) {
+			return tags, fmt.Errorf("tag '%s' may only contain letters, numbers and _ . : -", tag)
+		}
+	}
+	return tags, nil
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func validateEnv(env string) (string, error) {
+	for _, variable := range splitEnv(env) {
+		if variable = strings.TrimSpace(variable); len(variable) == 0 {
+			continue
+		}
+		name, _, found := strings.Cut(variable, "=")
+		if !found {
+			return env, fmt.Errorf("'%s' is missing a value, use NAME=value (write \\, for a comma inside a value)", variable)
+		} else if !envNamePattern.MatchString(strings.TrimSpace(name)) {
+			return env, fmt.Errorf("'%s' is not a valid variable name", name)
+		}
+	}
+	return env, nil
+}
+
 func validatePassword(password string) (string, error) {
 	if len(password) == 0 {
 		return password, fmt.Errorf("password cannot be empty")
@@ -172,6 +281,8 @@ func exportProfilesToCSV(path string, header []string, profiles []database.SSHPr
 		authType := database.GetNameFromAuthType(profile.AuthType)
 		if profile.AuthType == database.AuthTypePassword {
 			auth = profile.Password
+		} else if profile.AuthType == database.AuthTypeAgent {
+			auth = profile.AgentKey
 		} else {
 			auth = string(profile.PrivateKey[:])
 		}
@@ -180,7 +291,7 @@ func exportProfilesToCSV(path string, header []string, profiles []database.SSHPr
 		if profile.Encrypted {
 			encrypted = "+"
 		}
-		data = append(data, []string{fmt.Sprintf("%d", profile.Id), profile.Alias, profile.User, profile.Host, authType, auth, encrypted, profile.CTime.Format(dFormat)})
+		data = append(data, []string{fmt.Sprintf("%d", profile.Id), profile.Alias, profile.User, profile.Host, authType, auth, encrypted, profile.CTime.Format(dFormat), fmt.Sprintf("%d", profile.Port), profile.SecretRef})
 	}
 
 	file, err := os.Create(path)
@@ -193,4 +304,91 @@ func exportProfilesToCSV(path string, header []string, profiles []database.SSHPr
 	w.Flush()
 
 	return nil
-}
\ No newline at end of file
+}
+
+// parseTags splits a comma separated list into unique, lower-case and sorted tags
+func parseTags(input string) []string {
+	var tags []string
+
+	for _, tag := range strings.Split(input, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if len(tag) == 0 || slices.Contains(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// parseEnv splits a comma separated list of NAME=value pairs, later pairs overwrite earlier ones
+func parseEnv(input string) map[string]string {
+	env := make(map[string]string)
+
+	for _, variable := range splitEnv(input) {
+		name, value, found := strings.Cut(strings.TrimSpace(variable), "=")
+		if !found || len(strings.TrimSpace(name)) == 0 {
+			continue
+		}
+		env[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if len(env) == 0 {
+		return nil
+	}
+	return env
+}
+
+// splitEnv splits the input at every comma that isn't escaped, \, is a comma and \\ a backslash inside a value.
+// Any other backslash is kept as it is.
+func splitEnv(input string) []string {
+	var variables []string
+	var variable strings.Builder
+
+	for i := 0; i < len(input); i++ {
+		switch {
+		case input[i] == '\\' && i+1 < len(input) && (input[i+1] == ',' || input[i+1] == '\\'):
+			i++
+			variable.WriteByte(input[i])
+		case input[i] == ',':
+			variables = append(variables, variable.String())
+			variable.Reset()
+		default:
+			variable.WriteByte(input[i])
+		}
+	}
+	return append(variables, variable.String())
+}
+
+// formatEnv renders the variables in the format parseEnv reads, sorted by name
+func formatEnv(env map[string]string) string {
+	var variables []string
+
+	escape := strings.NewReplacer(`\`, `\\`, ",", `\,`)
+	for name, value := range env {
+		variables = append(variables, fmt.Sprintf("%s=%s", name, escape.Replace(value)))
+	}
+	slices.Sort(variables)
+	return strings.Join(variables, ", ")
+}
+
+// filterProfilesByTags returns the profiles that have all of the provided tags
+func filterProfilesByTags(profiles []database.SSHProfileSummary, tags []string) []database.SSHProfileSummary {
+	if len(tags) == 0 {
+		return profiles
+	}
+
+	var filtered []database.SSHProfileSummary
+	for _, profile := range profiles {
+		matches := true
+		for _, tag := range tags {
+			if !slices.Contains(profile.Tags, strings.ToLower(tag)) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, profile)
+		}
+	}
+	return filtered
+}
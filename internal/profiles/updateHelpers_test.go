@@ -0,0 +1,54 @@
+package profiles
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestEnvRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"plain", map[string]string{"LANG": "en_US.UTF-8", "TERM": "xterm"}},
+		{"comma", map[string]string{"PATH_LIST": "a,b,c"}},
+		{"backslash", map[string]string{"WIN_PATH": `C:\Users\me`}},
+		{"trailing backslash", map[string]string{"DIR": `C:\`, "NEXT": "value"}},
+		{"escaped comma", map[string]string{"LITERAL": `a\,b`}},
+		{"equal sign", map[string]string{"OPTS": "-Dkey=value", "EMPTY": ""}},
+		{"everything", map[string]string{"MIXED": `x=1,y=\2\,z`, "OTHER": `\\,,==`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted := formatEnv(tt.env)
+			if parsed := parseEnv(formatted); !maps.Equal(parsed, tt.env) {
+				t.Fatalf("%q was parsed as %q, expected %q", formatted, parsed, tt.env)
+			}
+		})
+	}
+}
+
+func TestSplitEnv(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", []string{""}},
+		{"A=1", []string{"A=1"}},
+		{"A=1,B=2", []string{"A=1", "B=2"}},
+		{`A=1\,2,B=3`, []string{"A=1,2", "B=3"}},
+		{`A=1\\,B=2`, []string{`A=1\`, "B=2"}},
+		{`A=1\\\,2`, []string{`A=1\,2`}},
+		{`A=C:\dir`, []string{`A=C:\dir`}},
+		{`A=1\`, []string{`A=1\`}},
+		{"A=x=y", []string{"A=x=y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := splitEnv(tt.input); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
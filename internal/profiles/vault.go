@@ -0,0 +1,396 @@
+package profiles
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mikeunge/sshman/internal/database"
+	"github.com/mikeunge/sshman/pkg/helpers"
+
+	"github.com/pterm/pterm"
+)
+
+// Encrypted with the vault key to verify the master password without touching any profile
+const vaultCheckValue = "sshman-vault"
+
+// Defaults used if the service doesn't set the KDF parameters
+const (
+	defaultKDFIterations = 3
+	defaultKDFMemory     = 64 * 1024
+	defaultKDFThreads    = 4
+)
+
+// masterKey returns the vault key, it is empty if vault mode isn't enabled.
+func (s *ProfileService) masterKey() (string, error) {
+	if len(s.masterKeyCache) > 0 {
+		return s.masterKeyCache, nil
+	}
+
+	vault, err := s.Store.GetVault()
+	if err != nil || vault == nil {
+		return "", err
+	}
+
+	if key, ok := s.agentKey(vaultKeyName(*vault)); ok && verifyVaultKey(*vault, key) {
+		s.masterKeyCache = key
+		return key, nil
+	}
+
+	for currentTry := 1; ; currentTry++ {
+		input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)).WithDefaultText("\nMaster password")
+		if s.MaskInput {
+			input.Mask = "*"
+		}
+		password, _ := input.Show()
+
+		key, err := deriveVaultKey(*vault, password)
+		if err != nil {
+			return "", err
+		}
+		if verifyVaultKey(*vault, key) {
+			s.masterKeyCache = key
+			s.rememberKey(vaultKeyName(*vault), key)
+			return key, nil
+		}
+		if currentTry >= s.DecryptionRetries {
+			return "", fmt.Errorf("wrong master password")
+		}
+		pterm.Warning.Println("Wrong master password, please try again...")
+	}
+}
+
+func verifyVaultKey(vault database.Vault, key string) bool {
+	check, err := helpers.DecryptString(vault.Check, key)
+	return err == nil && string(check) == vaultCheckValue
+}
+
+func deriveVaultKey(vault database.Vault, password string) (string, error) {
+	return helpers.DeriveKey(password, vaultKDF(vault))
+}
+
+func vaultKDF(vault database.Vault) helpers.KDF {
+	return helpers.KDF{Id: vault.KDF, Salt: vault.Salt, Iterations: vault.Iterations, Memory: vault.Memory, Threads: vault.Threads}
+}
+
+// Outside of vault mode every profile key is the plain SHA-256 of its passphrase
+var profileKDF = helpers.KDF{Id: helpers.KDFSHA256}
+
+// keyKDF returns how the current encryption keys are derived, vault mode decides between the master password and profile keys.
+func (s *ProfileService) keyKDF() (helpers.KDF, error) {
+	vault, err := s.Store.GetVault()
+	if err != nil || vault == nil {
+		return profileKDF, err
+	}
+	return vaultKDF(*vault), nil
+}
+
+// newArgon2idKDF returns the configured Argon2id parameters with a new random salt.
+func (s *ProfileService) newArgon2idKDF() (helpers.KDF, error) {
+	kdf := helpers.KDF{
+		Id:         helpers.KDFArgon2id,
+		Iterations: s.KDFIterations,
+		Memory:     s.KDFMemory,
+		Threads:    s.KDFThreads,
+	}
+	if kdf.Iterations == 0 {
+		kdf.Iterations = defaultKDFIterations
+	}
+	if kdf.Memory == 0 {
+		kdf.Memory = defaultKDFMemory
+	}
+	if kdf.Threads == 0 {
+		kdf.Threads = defaultKDFThreads
+	}
+
+	var err error
+	kdf.Salt, err = helpers.RandomBytes(16)
+	return kdf, err
+}
+
+// newVault creates the settings of a new vault and derives its key from the master password.
+func (s *ProfileService) newVault(password string) (database.Vault, string, error) {
+	kdf, err := s.newArgon2idKDF()
+	if err != nil {
+		return database.Vault{}, "", err
+	}
+	vault := database.Vault{KDF: kdf.Id, Salt: kdf.Salt, Iterations: kdf.Iterations, Memory: kdf.Memory, Threads: kdf.Threads}
+
+	key, err := deriveVaultKey(vault, password)
+	if err != nil {
+		return vault, "", err
+	}
+	if vault.Check, err = helpers.EncryptString([]byte(vaultCheckValue), key, vaultKDF(vault)); err != nil {
+		return vault, "", err
+	}
+	return vault, key, nil
+}
+
+// askNewPassword asks for a password twice, so a typo can't lock the profiles forever.
+func (s *ProfileService) askNewPassword(text string) (string, error) {
+	input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault))
+	if s.MaskInput {
+		input.Mask = "*"
+	}
+
+	password, _ := input.WithDefaultText(text).Show()
+	if len(password) == 0 {
+		return "", fmt.Errorf("the %s cannot be empty", strings.ToLower(text))
+	}
+	repeated, _ := input.WithDefaultText(text + " (repeat)").Show()
+	if password != repeated {
+		return "", fmt.Errorf("the %ss don't match", strings.ToLower(text))
+	}
+	return password, nil
+}
+
+// reencryptProfiles decrypts the secrets of the profiles with their current key and encrypts them with key.
+// Every key that worked is tried first for the following profiles, so a shared key only has to be entered once.
+// The keys that worked are returned, so the revisions of the profiles can be re-encrypted without asking again.
+func (s *ProfileService) reencryptProfiles(profiles []database.SSHProfile, key string, kdf helpers.KDF) ([]string, error) {
+	var knownKeys []string
+
+	for i := range profiles {
+		profile := &profiles[i]
+		if !profile.Encrypted || len(secretOf(*profile)) == 0 {
+			continue
+		}
+
+		var secret helpers.Secret
+		decrypted := slices.ContainsFunc(knownKeys, func(k string) bool { return decryptWithKey(*profile, k, &secret) })
+		for currentTry := 1; !decrypted; currentTry++ {
+			input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)).WithDefaultText(fmt.Sprintf("\nDecryption Key (%s)", profile.Alias))
+			if s.MaskInput {
+				input.Mask = "*"
+			}
+			encKey, _ := input.Show()
+
+			hash := helpers.CreateHash(encKey)
+			var err error
+			if secret, err = decryptSecret(*profile, hash); err == nil {
+				knownKeys = append(knownKeys, hash)
+				decrypted = true
+			} else if currentTry >= s.DecryptionRetries {
+				return nil, fmt.Errorf("could not decrypt profile %s, %s", profile.Alias, err.Error())
+			} else {
+				pterm.Warning.Println("Wrong password, please try again...")
+			}
+		}
+
+		err := encryptSecret(profile, secret, key, kdf)
+		secret.Wipe()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return knownKeys, nil
+}
+
+// reencryptRevisions encrypts the secrets of the revisions with key, every revision has to be encrypted with one of keys.
+// The revisions that couldn't be decrypted aren't returned, SaveVault deletes them.
+func reencryptRevisions(revisions []database.ProfileRevision, keys []string, key string, kdf helpers.KDF) ([]database.ProfileRevision, int, error) {
+	var reencrypted []database.ProfileRevision
+
+	for _, revision := range revisions {
+		var secret helpers.Secret
+		if len(secretOf(revision.Profile)) > 0 && !slices.ContainsFunc(keys, func(k string) bool { return decryptWithKey(revision.Profile, k, &secret) }) {
+			continue
+		}
+		// Only the secret of the auth type is kept, a leftover of another auth type would still be encrypted with the old key
+		revision.Profile.Password, revision.Profile.PrivateKey = "", nil
+		if len(secret) == 0 {
+			reencrypted = append(reencrypted, revision)
+			continue
+		}
+		err := encryptSecret(&revision.Profile, secret, key, kdf)
+		secret.Wipe()
+		if err != nil {
+			return nil, 0, err
+		}
+		reencrypted = append(reencrypted, revision)
+	}
+	return reencrypted, len(revisions) - len(reencrypted), nil
+}
+
+// confirmDroppedRevisions asks before deleting the revisions whose secret is encrypted with a key that wasn't provided.
+// They can't stay, rolling back to them would restore a secret the new key can't decrypt.
+func confirmDroppedRevisions(dropped int) bool {
+	if dropped == 0 {
+		return true
+	}
+	pterm.Warning.Printf("%d revision(s) hold a secret encrypted with a key that wasn't provided, they can't be re-encrypted and will be deleted.\n", dropped)
+	ok, _ := pterm.DefaultInteractiveConfirm.WithDefaultText("Delete these revisions and continue?").Show()
+	return ok
+}
+
+// InitVault enables vault mode, all encrypted profiles get re-encrypted with the key derived from the master password.
+func (s *ProfileService) InitVault() error {
+	vault, err := s.Store.GetVault()
+	if err != nil {
+		return err
+	}
+	if vault != nil {
+		return fmt.Errorf("vault mode is already enabled, use 'sshman rekey' to change the master password")
+	}
+
+	// Profiles in the trash are included, otherwise they couldn't be decrypted after restoring them
+	profiles, err := s.Store.GetEncryptedSSHProfiles()
+	if err != nil {
+		return err
+	}
+	// Revisions are included, otherwise a rollback would restore a secret vault mode can't decrypt
+	revisions, err := s.Store.GetEncryptedRevisions()
+	if err != nil {
+		return err
+	}
+
+	pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Println("Enabling vault mode")
+	password, err := s.askNewPassword("Master password")
+	if err != nil {
+		return err
+	}
+	newVault, key, err := s.newVault(password)
+	if err != nil {
+		return err
+	}
+
+	if len(profiles) > 0 {
+		pterm.Info.Printf("%d encrypted profile(s) will be re-encrypted with the master password, please provide their current keys.\n", len(profiles))
+	}
+	knownKeys, err := s.reencryptProfiles(profiles, key, vaultKDF(newVault))
+	if err != nil {
+		return fmt.Errorf("could not enable vault mode, nothing was changed.\n%s", err.Error())
+	}
+	revisions, dropped, err := reencryptRevisions(revisions, knownKeys, key, vaultKDF(newVault))
+	if err != nil {
+		return fmt.Errorf("could not enable vault mode, nothing was changed.\n%s", err.Error())
+	}
+	if !confirmDroppedRevisions(dropped) {
+		return fmt.Errorf("vault mode wasn't enabled, nothing was changed")
+	}
+
+	if err = s.backup("vault"); err != nil {
+		return err
+	}
+	if err = s.Store.SaveVault(&newVault, profiles, revisions); err != nil {
+		return fmt.Errorf("could not enable vault mode, nothing was changed.\n%s", err.Error())
+	}
+	s.masterKeyCache = key
+	s.rememberKey(vaultKeyName(newVault), key)
+
+	fmt.Println()
+	pterm.Success.Printf("Vault mode enabled, %d profile(s) are now encrypted with the master password.\n", len(profiles))
+	return nil
+}
+
+// Rekey re-encrypts every encrypted profile and revision with a new key (or master password in vault mode) in a single transaction.
+// If a single profile can't be decrypted with the current key nothing is changed, revisions that can't are deleted after asking.
+func (s *ProfileService) Rekey() error {
+	profiles, err := s.Store.GetEncryptedSSHProfiles()
+	if err != nil {
+		return err
+	}
+	revisions, err := s.Store.GetEncryptedRevisions()
+	if err != nil {
+		return err
+	}
+	vault, err := s.Store.GetVault()
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 && vault == nil {
+		return fmt.Errorf("there are no encrypted profiles")
+	}
+
+	var oldKey, newKey string
+	newKDF := profileKDF
+	if vault != nil {
+		pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Println("Changing the master password")
+		if oldKey, err = s.masterKey(); err != nil {
+			return err
+		}
+		password, err := s.askNewPassword("New master password")
+		if err != nil {
+			return err
+		}
+		// The new vault gets a new salt and the currently configured KDF parameters
+		newVault, key, err := s.newVault(password)
+		if err != nil {
+			return err
+		}
+		vault, newKey, newKDF = &newVault, key, vaultKDF(newVault)
+	} else {
+		pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Printf("Changing the encryption key of %d profile(s)\n", len(profiles))
+		input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)).WithDefaultText("Current encryption key")
+		if s.MaskInput {
+			input.Mask = "*"
+		}
+		encKey, _ := input.Show()
+		oldKey = helpers.CreateHash(encKey)
+
+		password, err := s.askNewPassword("New encryption key")
+		if err != nil {
+			return err
+		}
+		newKey = helpers.CreateHash(password)
+	}
+
+	for i := range profiles {
+		secret, err := decryptSecret(profiles[i], oldKey)
+		if err != nil {
+			return fmt.Errorf("could not decrypt profile %s with the current key, nothing was changed", profiles[i].Alias)
+		}
+		err = encryptSecret(&profiles[i], secret, newKey, newKDF)
+		secret.Wipe()
+		if err != nil {
+			return err
+		}
+	}
+	revisions, dropped, err := reencryptRevisions(revisions, []string{oldKey}, newKey, newKDF)
+	if err != nil {
+		return err
+	}
+	if !confirmDroppedRevisions(dropped) {
+		return fmt.Errorf("the key wasn't changed, nothing was changed")
+	}
+
+	backup, err := s.backupPath("rekey")
+	if err != nil {
+		return err
+	}
+	if err = s.Store.SaveVault(vault, profiles, revisions); err != nil {
+		return fmt.Errorf("could not change the key, nothing was changed.\n%s", err.Error())
+	}
+	if len(s.masterKeyCache) > 0 {
+		s.masterKeyCache = newKey
+		s.rememberKey(vaultKeyName(*vault), newKey)
+	}
+
+	fmt.Println()
+	pterm.Success.Printf("Re-encrypted %d profile(s) and %d revision(s) with the new key.\n", len(profiles), len(revisions))
+	if len(backup) > 0 {
+		pterm.Warning.Printf("The backup %s (and every older one) still contains the secrets encrypted with the old key, delete them once they aren't needed anymore.\n", backup)
+	}
+	return nil
+}
+
+func (s *ProfileService) VaultStatus() error {
+	vault, err := s.Store.GetVault()
+	if err != nil {
+		return err
+	}
+	if vault == nil {
+		pterm.Info.Println("Vault mode is disabled, enable it with 'sshman vault init'.")
+		return nil
+	}
+
+	pterm.Info.Printf("Vault mode is enabled since %s.\n", vault.CTime.Local().Format("02.01.2006 15:04"))
+	pterm.DefaultTable.
+		WithHasHeader().
+		WithData([][]string{
+			{"KDF", "Iterations", "Memory", "Threads"}, // define the table header
+			{vault.KDF, fmt.Sprintf("%d", vault.Iterations), fmt.Sprintf("%d MiB", vault.Memory/1024), fmt.Sprintf("%d", vault.Threads)},
+		}).
+		Render()
+	return nil
+}
@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Placeholder replaced with the reference of the profile, e.g. "pass show {ref}"
+const RefPlaceholder = "{ref}"
+
+// Provider resolves the secret of a profile from somewhere outside of the sshman database.
+type Provider interface {
+	Secret(ref string) ([]byte, error)
+}
+
+// Command is a provider that runs a local command and uses its output as the secret.
+// The command isn't run through a shell, so a reference can't inject anything into it.
+type Command struct {
+	Name    string
+	Command string
+}
+
+func (c Command) Secret(ref string) ([]byte, error) {
+	args := strings.Fields(c.Command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("secret provider '%s' has no command", c.Name)
+	}
+
+	replaced := false
+	for i, arg := range args {
+		if strings.Contains(arg, RefPlaceholder) {
+			args[i] = strings.ReplaceAll(arg, RefPlaceholder, ref)
+			replaced = true
+		}
+	}
+	if !replaced {
+		args = append(args, ref)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	// The command may have to ask for a passphrase itself (e.g. gpg), so it shares the terminal
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("secret provider '%s' failed for '%s', %s", c.Name, ref, err.Error())
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("secret provider '%s' returned an empty secret for '%s'", c.Name, ref)
+	}
+	return stdout.Bytes(), nil
+}
+
+// NewProviders creates a command provider for every configured name.
+func NewProviders(commands map[string]string) map[string]Provider {
+	providers := make(map[string]Provider, len(commands))
+	for name, command := range commands {
+		providers[name] = Command{Name: name, Command: command}
+	}
+	return providers
+}
+
+// FormatRef joins a provider and the reference it understands, e.g. "pass:servers/db1".
+func FormatRef(provider string, ref string) string {
+	return provider + ":" + ref
+}
+
+// ParseRef splits a secret reference into its provider and the reference passed to it.
+func ParseRef(secretRef string) (string, string, error) {
+	provider, ref, ok := strings.Cut(secretRef, ":")
+	if !ok || len(provider) == 0 || len(ref) == 0 {
+		return "", "", fmt.Errorf("invalid secret reference '%s', expected <provider>:<reference>", secretRef)
+	}
+	return provider, ref, nil
+}
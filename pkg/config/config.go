@@ -14,13 +14,30 @@ const (
 	defaultLoggingPath       = "~/.local/share/sshman/sshman.log"
 	defaultMaskInput         = true
 	defaultDecryptionRetries = 1
+	defaultTrashRetention    = 30
+	defaultStorage           = "sqlite"
+	defaultBackupRotation    = 5
+	defaultKDFIterations     = 3
+	defaultKDFMemory         = 64 * 1024
+	defaultKDFThreads        = 4
+	defaultAgentTimeout      = 15
 )
 
 type Config struct {
+	Storage           string `json:"storage"`
 	DatabasePath      string `json:"databasepath"`
 	LoggingPath       string `json:"logpath"`
 	MaskInput         bool   `json:"maskInput"`
 	DecryptionRetries int    `json:"decryptionRetries"`
+	TrashRetention    int    `json:"trashRetentionDays"`
+	BackupRotation    int    `json:"backupRotation"` // negative disables automatic backups
+	KDFIterations     int    `json:"kdfIterations"`  // Argon2id parameters used when the vault gets created
+	KDFMemory         int    `json:"kdfMemory"`      // in KiB
+	KDFThreads        int    `json:"kdfThreads"`
+	AgentTimeout      int    `json:"agentTimeoutMinutes"` // the agent wipes its keys after being idle for this long
+	UseKnownHosts     bool   `json:"useKnownHosts"`       // trust the host keys in ~/.ssh/known_hosts as well
+
+	SecretProviders map[string]string `json:"secretProviders"` // name -> command printing the secret, e.g. "pass show {ref}"
 }
 
 // Paths to validate
@@ -46,6 +63,28 @@ func Parse(path string) (Config, error) {
 		return config, err
 	}
 
+	if config.Storage == "" {
+		config.Storage = defaultStorage
+	}
+	if config.TrashRetention <= 0 {
+		config.TrashRetention = defaultTrashRetention
+	}
+	if config.BackupRotation == 0 {
+		config.BackupRotation = defaultBackupRotation
+	}
+	if config.KDFIterations <= 0 {
+		config.KDFIterations = defaultKDFIterations
+	}
+	if config.KDFMemory <= 0 {
+		config.KDFMemory = defaultKDFMemory
+	}
+	if config.KDFThreads <= 0 || config.KDFThreads > 255 {
+		config.KDFThreads = defaultKDFThreads
+	}
+	if config.AgentTimeout <= 0 {
+		config.AgentTimeout = defaultAgentTimeout
+	}
+
 	config.sanitizeConfigPaths()
 	if err := config.validatePaths(PathsToValidate, true); err != nil {
 		return config, err
@@ -87,10 +126,17 @@ func (c *Config) validatePaths(objectNames []string, createIfNotExist bool) erro
 
 func defaultConfig() Config {
 	config := Config{
+		Storage:           defaultStorage,
 		DatabasePath:      defaultDatabasePath,
 		LoggingPath:       defaultLoggingPath,
 		MaskInput:         defaultMaskInput,
 		DecryptionRetries: defaultDecryptionRetries,
+		TrashRetention:    defaultTrashRetention,
+		BackupRotation:    defaultBackupRotation,
+		KDFIterations:     defaultKDFIterations,
+		KDFMemory:         defaultKDFMemory,
+		KDFThreads:        defaultKDFThreads,
+		AgentTimeout:      defaultAgentTimeout,
 	}
 	config.sanitizeConfigPaths()
 	config.validatePaths(PathsToValidate, true)
@@ -0,0 +1,191 @@
+package helpers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Key derivation functions that can be recorded in an envelope
+const (
+	KDFSHA256   = "sha256" // a single unsalted SHA-256 of the passphrase, see CreateHash
+	KDFArgon2id = "argon2id"
+)
+
+const (
+	envelopePrefix  = "$sshman$"
+	envelopeVersion = 1
+	envelopeCipher  = "aes-256-gcm"
+)
+
+// Bounds of the Argon2id parameters, envelopes outside of them are rejected before a key gets derived
+const (
+	MinKDFSaltLength = 8
+	MaxKDFIterations = 100
+	MaxKDFMemory     = 1024 * 1024 // in KiB, 1 GiB
+)
+
+// KDF describes how an encryption key was derived from its passphrase.
+type KDF struct {
+	Id         string
+	Salt       []byte
+	Iterations uint32
+	Memory     uint32 // in KiB
+	Threads    uint8
+}
+
+// DeriveKey derives the key of a passphrase, the key can be used with EncryptString and DecryptString.
+// The key is returned as a hex string, it can't be wiped and stays in memory until the garbage collector reuses it.
+// The parameters are validated first, argon2 panics on some of them and others would exhaust the memory.
+func DeriveKey(password string, kdf KDF) (string, error) {
+	if err := ValidateKDF(kdf); err != nil {
+		return "", err
+	}
+
+	switch kdf.Id {
+	case KDFSHA256:
+		return CreateHash(password), nil
+	case KDFArgon2id:
+		return fmt.Sprintf("%x", argon2.IDKey([]byte(password), kdf.Salt, kdf.Iterations, kdf.Memory, kdf.Threads, 32)), nil
+	default:
+		return "", fmt.Errorf("unknown key derivation function '%s'", kdf.Id)
+	}
+}
+
+// ValidateKDF makes sure deriving a key with kdf neither fails nor takes an unreasonable amount of time or memory.
+func ValidateKDF(kdf KDF) error {
+	switch kdf.Id {
+	case KDFSHA256:
+		if len(kdf.Salt) > 0 || kdf.Iterations != 0 || kdf.Memory != 0 || kdf.Threads != 0 {
+			return fmt.Errorf("the key derivation function '%s' doesn't take a salt or parameters", kdf.Id)
+		}
+	case KDFArgon2id:
+		if len(kdf.Salt) < MinKDFSaltLength {
+			return fmt.Errorf("the salt must be at least %d bytes long", MinKDFSaltLength)
+		}
+		if kdf.Iterations < 1 || kdf.Iterations > MaxKDFIterations {
+			return fmt.Errorf("the iterations must be between 1 and %d, got %d", MaxKDFIterations, kdf.Iterations)
+		}
+		if kdf.Threads < 1 {
+			return fmt.Errorf("the threads must be between 1 and 255, got %d", kdf.Threads)
+		}
+		// Argon2 needs at least 8 KiB per thread
+		if kdf.Memory < 8*uint32(kdf.Threads) || kdf.Memory > MaxKDFMemory {
+			return fmt.Errorf("the memory must be between %d and %d KiB, got %d", 8*uint32(kdf.Threads), MaxKDFMemory, kdf.Memory)
+		}
+	default:
+		return fmt.Errorf("unknown key derivation function '%s'", kdf.Id)
+	}
+	return nil
+}
+
+// IsLegacyCiphertext reports whether data was encrypted before envelopes existed (bare hex of nonce and ciphertext).
+func IsLegacyCiphertext(data string) bool {
+	return !strings.HasPrefix(data, envelopePrefix)
+}
+
+// EnvelopeKDF returns the key derivation recorded in the envelope of data.
+func EnvelopeKDF(data string) (KDF, error) {
+	kdf, _, _, err := parseEnvelope(data)
+	return kdf, err
+}
+
+// envelopeHeader returns everything in front of the sealed data, e.g. $sshman$v=1$aes-256-gcm$argon2id$t=3,m=65536,p=4$<salt>$
+// The parameters and salt are empty for keys that don't use them. The header is authenticated as additional data of the cipher.
+func envelopeHeader(kdf KDF) string {
+	var params string
+	if kdf.Id == KDFArgon2id {
+		params = fmt.Sprintf("t=%d,m=%d,p=%d", kdf.Iterations, kdf.Memory, kdf.Threads)
+	}
+	return fmt.Sprintf("%sv=%d$%s$%s$%s$%x$", envelopePrefix, envelopeVersion, envelopeCipher, kdf.Id, params, kdf.Salt)
+}
+
+// parseEnvelope returns the key derivation, the header and the sealed data (nonce and ciphertext) of an envelope.
+// Anything that doesn't match what envelopeHeader writes is rejected.
+func parseEnvelope(data string) (KDF, []byte, []byte, error) {
+	var kdf KDF
+
+	parts := strings.Split(strings.TrimPrefix(data, envelopePrefix), "$")
+	if IsLegacyCiphertext(data) || len(parts) != 6 {
+		return kdf, nil, nil, fmt.Errorf("encrypted data is not a valid envelope")
+	}
+	if parts[0] != fmt.Sprintf("v=%d", envelopeVersion) {
+		return kdf, nil, nil, fmt.Errorf("unsupported envelope version '%s', please update sshman", parts[0])
+	}
+	if parts[1] != envelopeCipher {
+		return kdf, nil, nil, fmt.Errorf("unsupported cipher '%s'", parts[1])
+	}
+
+	kdf.Id = parts[2]
+	if err := parseKDFParams(&kdf, parts[3]); err != nil {
+		return kdf, nil, nil, err
+	}
+
+	var err error
+	if kdf.Salt, err = hex.DecodeString(parts[4]); err != nil {
+		return kdf, nil, nil, fmt.Errorf("invalid salt, %s", err.Error())
+	}
+	if len(kdf.Salt) == 0 {
+		kdf.Salt = nil
+	}
+	if err = ValidateKDF(kdf); err != nil {
+		return kdf, nil, nil, fmt.Errorf("invalid key derivation, %s", err.Error())
+	}
+
+	sealed, err := hex.DecodeString(parts[5])
+	if err != nil {
+		return kdf, nil, nil, fmt.Errorf("invalid ciphertext, %s", err.Error())
+	}
+	header := data[:len(data)-len(parts[5])]
+	return kdf, []byte(header), sealed, nil
+}
+
+// parseKDFParams sets the Argon2id parameters, every parameter has to be given exactly once and fit its type.
+// Other key derivation functions don't take parameters.
+func parseKDFParams(kdf *KDF, params string) error {
+	if kdf.Id != KDFArgon2id {
+		if len(params) > 0 {
+			return fmt.Errorf("the key derivation function '%s' doesn't take parameters", kdf.Id)
+		}
+		return nil
+	}
+
+	if len(params) == 0 {
+		return fmt.Errorf("the key derivation function '%s' needs the parameters t, m and p", kdf.Id)
+	}
+
+	seen := make(map[string]bool)
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(param, "=")
+		if seen[name] {
+			return fmt.Errorf("duplicate key derivation parameter '%s'", name)
+		}
+		seen[name] = true
+
+		var err error
+		var n uint64
+		switch name {
+		case "t":
+			n, err = strconv.ParseUint(value, 10, 32)
+			kdf.Iterations = uint32(n)
+		case "m":
+			n, err = strconv.ParseUint(value, 10, 32)
+			kdf.Memory = uint32(n)
+		case "p":
+			n, err = strconv.ParseUint(value, 10, 8)
+			kdf.Threads = uint8(n)
+		default:
+			return fmt.Errorf("unknown key derivation parameter '%s'", param)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid key derivation parameter '%s'", param)
+		}
+	}
+	if len(seen) != 3 {
+		return fmt.Errorf("the key derivation parameters '%s' are incomplete, expected t, m and p", params)
+	}
+	return nil
+}
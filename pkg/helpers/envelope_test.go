@@ -0,0 +1,180 @@
+package helpers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testKDF is as cheap as Argon2id gets, the tests only care about the envelope.
+var testKDF = KDF{Id: KDFArgon2id, Salt: []byte("saltsalt"), Iterations: 1, Memory: 64, Threads: 1}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		kdf      KDF
+		password string
+	}{
+		{"sha256", KDF{Id: KDFSHA256}, "secret"},
+		{"argon2id", testKDF, "secret"},
+		{"empty secret", testKDF, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := DeriveKey("passphrase", tt.kdf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed, err := EncryptString([]byte(tt.password), key, tt.kdf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(sealed, envelopeHeader(tt.kdf)) {
+				t.Fatalf("%q doesn't start with the envelope header", sealed)
+			}
+			kdf, err := EnvelopeKDF(sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kdf.Id != tt.kdf.Id || string(kdf.Salt) != string(tt.kdf.Salt) || kdf.Iterations != tt.kdf.Iterations || kdf.Memory != tt.kdf.Memory || kdf.Threads != tt.kdf.Threads {
+				t.Fatalf("got the key derivation %+v, expected %+v", kdf, tt.kdf)
+			}
+
+			plain, err := DecryptString(sealed, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(plain) != tt.password {
+				t.Fatalf("got %q, expected %q", plain, tt.password)
+			}
+			if _, err = DecryptString(sealed, CreateHash("wrong")); err == nil {
+				t.Fatal("decrypting with the wrong key succeeded")
+			}
+		})
+	}
+}
+
+// Data encrypted before envelopes existed is the bare hex of nonce and ciphertext without additional data.
+func TestDecryptLegacyCiphertext(t *testing.T) {
+	key := CreateHash("passphrase")
+	raw, _ := hex.DecodeString(key)
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	legacy := hex.EncodeToString(aesGCM.Seal(nonce, nonce, []byte("secret"), nil))
+
+	if !IsLegacyCiphertext(legacy) {
+		t.Fatalf("%q isn't recognized as legacy ciphertext", legacy)
+	}
+	plain, err := DecryptString(legacy, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != "secret" {
+		t.Fatalf("got %q, expected %q", plain, "secret")
+	}
+}
+
+// The header is authenticated, changing any field of it must make the decryption fail.
+func TestTamperedEnvelopeHeader(t *testing.T) {
+	key, err := DeriveKey("passphrase", testKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := EncryptString([]byte("secret"), key, testKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := envelopeHeader(testKDF)
+	salt := hex.EncodeToString(testKDF.Salt)
+
+	tests := []struct {
+		name     string
+		old, new string
+	}{
+		{"version", "$v=1$", "$v=2$"},
+		{"cipher", "$aes-256-gcm$", "$aes-128-gcm$"},
+		{"kdf", "$argon2id$t=1,m=64,p=1$", "$sha256$$"},
+		{"iterations", "t=1,", "t=2,"},
+		{"memory", "m=64,", "m=128,"},
+		{"threads", "p=1$", "p=2$"},
+		{"parameter order", "t=1,m=64,p=1", "m=64,t=1,p=1"},
+		{"salt", salt, hex.EncodeToString([]byte("saltsalz"))},
+		{"salt case", salt, strings.ToUpper(salt)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(header, tt.old) {
+				t.Fatalf("the header %q doesn't contain %q", header, tt.old)
+			}
+			tampered := strings.Replace(header, tt.old, tt.new, 1) + strings.TrimPrefix(sealed, header)
+			if _, err := DecryptString(tampered, key); err == nil {
+				t.Fatalf("%q was decrypted", tampered)
+			}
+		})
+	}
+}
+
+func TestValidateKDF(t *testing.T) {
+	tests := []struct {
+		name  string
+		kdf   KDF
+		valid bool
+	}{
+		{"sha256", KDF{Id: KDFSHA256}, true},
+		{"sha256 with a salt", KDF{Id: KDFSHA256, Salt: []byte("saltsalt")}, false},
+		{"sha256 with parameters", KDF{Id: KDFSHA256, Iterations: 1}, false},
+		{"argon2id", testKDF, true},
+		{"argon2id at the limits", KDF{Id: KDFArgon2id, Salt: testKDF.Salt, Iterations: MaxKDFIterations, Memory: MaxKDFMemory, Threads: 255}, true},
+		{"short salt", KDF{Id: KDFArgon2id, Salt: []byte("salt"), Iterations: 1, Memory: 64, Threads: 1}, false},
+		{"no iterations", KDF{Id: KDFArgon2id, Salt: testKDF.Salt, Iterations: 0, Memory: 64, Threads: 1}, false},
+		{"too many iterations", KDF{Id: KDFArgon2id, Salt: testKDF.Salt, Iterations: MaxKDFIterations + 1, Memory: 64, Threads: 1}, false},
+		{"no threads", KDF{Id: KDFArgon2id, Salt: testKDF.Salt, Iterations: 1, Memory: 64, Threads: 0}, false},
+		{"too little memory per thread", KDF{Id: KDFArgon2id, Salt: testKDF.Salt, Iterations: 1, Memory: 15, Threads: 2}, false},
+		{"too much memory", KDF{Id: KDFArgon2id, Salt: testKDF.Salt, Iterations: 1, Memory: MaxKDFMemory + 1, Threads: 1}, false},
+		{"unknown", KDF{Id: "scrypt"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateKDF(tt.kdf); (err == nil) != tt.valid {
+				t.Fatalf("got %v, expected valid=%v", err, tt.valid)
+			}
+		})
+	}
+}
+
+// Envelopes with parameters out of range are rejected before a key gets derived from them.
+func TestParseEnvelopeRejectsParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params string
+		salt   string
+	}{
+		{"too many iterations", "t=101,m=64,p=1", "73616c7473616c74"},
+		{"too much memory", "t=1,m=4294967295,p=1", "73616c7473616c74"},
+		{"no threads", "t=1,m=64,p=0", "73616c7473616c74"},
+		{"threads overflow", "t=1,m=64,p=256", "73616c7473616c74"},
+		{"negative", "t=-1,m=64,p=1", "73616c7473616c74"},
+		{"missing parameter", "t=1,m=64", "73616c7473616c74"},
+		{"duplicate parameter", "t=1,t=1,m=64,p=1", "73616c7473616c74"},
+		{"unknown parameter", "t=1,m=64,p=1,x=1", "73616c7473616c74"},
+		{"short salt", "t=1,m=64,p=1", "73616c74"},
+		{"invalid salt", "t=1,m=64,p=1", "not hex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := envelopePrefix + "v=1$aes-256-gcm$argon2id$" + tt.params + "$" + tt.salt + "$00"
+			if _, err := EnvelopeKDF(data); err == nil {
+				t.Fatalf("%q was accepted", data)
+			}
+		})
+	}
+}
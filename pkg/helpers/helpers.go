@@ -3,6 +3,7 @@ package helpers
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -112,12 +113,30 @@ func GetFilesInDir(path string) ([]string, error) {
 	return files, nil
 }
 
+// CreateHash returns the SHA-256 of str as a hex string, used as the key of profiles outside of vault mode.
+// Like the keys of DeriveKey it can't be wiped.
 func CreateHash(str string) string {
 	hash := sha256.New()
 	hash.Write([]byte(str))
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
 
+// KeyCheck returns a value that verifies an encryption key before anything gets decrypted with it.
+func KeyCheck(encKey string) string {
+	key, _ := hex.DecodeString(encKey)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("sshman key check"))
+	return fmt.Sprintf("%x", mac.Sum(nil)[:16])
+}
+
+func RandomBytes(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 func WriteToFile(path string, data string, perm os.FileMode) error {
 	return os.WriteFile(path, []byte(data), perm)
 }
@@ -153,10 +172,17 @@ func IsValidUrl(uri string) bool {
 	return re.MatchString(uri)
 }
 
-func EncryptString(data string, encKey string) (string, error) {
+// EncryptString encrypts data with AES-256-GCM, the result is an envelope that records the cipher and how the key was derived.
+func EncryptString(data []byte, encKey string, kdf KDF) (string, error) {
+	// An envelope that can't be parsed again couldn't be decrypted either
+	if err := ValidateKDF(kdf); err != nil {
+		return "", err
+	}
+
 	//Since the key is in string, we need to convert decode it to bytes
 	key, _ := hex.DecodeString(encKey)
-	plaintext := []byte(data)
+	defer clear(key)
+	plaintext := data
 
 	//Create a new Cipher Block from the key
 	block, err := aes.NewCipher(key)
@@ -179,36 +205,51 @@ func EncryptString(data string, encKey string) (string, error) {
 	//Encrypt the data using aesGCM.Seal
 
 	//Since we don't want to save the nonce somewhere else in this case, we add it as a prefix to the encrypted data. The first nonce argument in Seal is the prefix.
-	ciphertext := aesGCM.Seal(nonce, nonce, plaintext, nil)
-	return fmt.Sprintf("%x", ciphertext), nil
+	// The header is authenticated as well, so the recorded key derivation can't be changed unnoticed
+	header := envelopeHeader(kdf)
+	ciphertext := aesGCM.Seal(nonce, nonce, plaintext, []byte(header))
+	return header + hex.EncodeToString(ciphertext), nil
 }
 
-func DecryptString(data string, encKey string) (string, error) {
+// DecryptString decrypts envelopes as well as data encrypted before envelopes existed.
+// The plaintext is returned as a byte slice, so the caller can wipe it once it isn't needed anymore.
+func DecryptString(data string, encKey string) (Secret, error) {
 	key, _ := hex.DecodeString(encKey)
+	defer clear(key)
 	enc, _ := hex.DecodeString(data)
+	var header []byte
+	if !IsLegacyCiphertext(data) {
+		var err error
+		if _, header, enc, err = parseEnvelope(data); err != nil {
+			return nil, err
+		}
+	}
 
 	//Create a new Cipher Block from the key
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	//Create a new GCM
 	aesGCM, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	//Get the nonce size
 	nonceSize := aesGCM.NonceSize()
 
 	//Extract the nonce from the encrypted data
+	if len(enc) < nonceSize {
+		return nil, fmt.Errorf("encrypted data is too short")
+	}
 	nonce, ciphertext := enc[:nonceSize], enc[nonceSize:]
 
 	//Decrypt the data
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, header)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(plaintext), nil
+	return plaintext, nil
 }
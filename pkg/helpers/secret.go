@@ -0,0 +1,36 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+)
+
+const redacted = "[redacted]"
+
+// Secret holds a plain password or private key, it never shows up when formatted, logged or marshalled.
+// Call Wipe once the secret isn't needed anymore, so it doesn't stay in memory until the garbage collector reuses it.
+// Only the plaintext is wiped: the keys that decrypt it (see DeriveKey and CreateHash) are hex strings Go can't overwrite,
+// and the memory and json storage backends hold every stored secret as long as the process runs.
+type Secret []byte
+
+// Wipe overwrites the secret with zeros.
+func (s Secret) Wipe() {
+	clear(s)
+}
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return redacted
+}
+
+// Format redacts every verb, including %x and %q.
+func (s Secret) Format(f fmt.State, verb rune) {
+	io.WriteString(f, redacted)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
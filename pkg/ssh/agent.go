@@ -0,0 +1,70 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	cryptSSH "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentKey is a key held by the ssh-agent, the key itself never leaves the agent.
+type AgentKey struct {
+	Type        string
+	Fingerprint string // SHA256, as shown by ssh-add -l
+	Comment     string
+}
+
+// dialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK, the caller closes the connection.
+func dialAgent() (agent.ExtendedAgent, net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if len(socket) == 0 {
+		return nil, nil, fmt.Errorf("SSH_AUTH_SOCK is not set, is the ssh-agent running?")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to the ssh-agent, %s", err.Error())
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// AgentKeys lists the keys held by the ssh-agent.
+func AgentKeys() ([]AgentKey, error) {
+	client, conn, err := dialAgent()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := client.List()
+	if err != nil {
+		return nil, err
+	}
+	var agentKeys []AgentKey
+	for _, key := range keys {
+		agentKeys = append(agentKeys, AgentKey{Type: key.Type(), Fingerprint: cryptSSH.FingerprintSHA256(key), Comment: key.Comment})
+	}
+	return agentKeys, nil
+}
+
+// agentSigners returns the signers of the agent, only the one matching the fingerprint if it isn't empty.
+func agentSigners(client agent.ExtendedAgent, fingerprint string) ([]cryptSSH.Signer, error) {
+	signers, err := client.Signers()
+	if err != nil {
+		return nil, err
+	}
+	if len(fingerprint) == 0 {
+		if len(signers) == 0 {
+			return nil, fmt.Errorf("the ssh-agent doesn't hold any keys, add one with ssh-add")
+		}
+		return signers, nil
+	}
+
+	for _, signer := range signers {
+		if cryptSSH.FingerprintSHA256(signer.PublicKey()) == fingerprint {
+			return []cryptSSH.Signer{signer}, nil
+		}
+	}
+	return nil, fmt.Errorf("the ssh-agent doesn't hold the key %s", fingerprint)
+}
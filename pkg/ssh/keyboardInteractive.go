@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"strings"
+
+	"github.com/pterm/pterm"
+	cryptSSH "golang.org/x/crypto/ssh"
+)
+
+// keyboardInteractive returns the auth method answering the prompts of the server (e.g. PAM or one-time passwords) on the terminal.
+// Servers that require a second factor after the key ask for it with this method as well.
+func (s *SSHServer) keyboardInteractive() cryptSSH.AuthMethod {
+	return cryptSSH.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(name) > 0 {
+			pterm.DefaultBasicText.WithStyle(pterm.NewStyle(pterm.Bold)).Println(name)
+		}
+		if len(instruction) > 0 {
+			pterm.Info.Println(strings.TrimSpace(instruction))
+		}
+
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			// pterm adds the colon itself
+			text := strings.TrimSuffix(strings.TrimSpace(question), ":")
+			input := pterm.DefaultInteractiveTextInput.WithTextStyle(pterm.NewStyle(pterm.FgDefault)).WithDefaultText(text)
+			// Answers the server doesn't echo (passwords, codes) are never shown
+			if !echos[i] {
+				input.Mask = "*"
+			}
+			answers[i], _ = input.Show()
+		}
+		return answers, nil
+	})
+}
@@ -37,6 +37,9 @@ func (s *SSHServer) SpawnShell(ctx context.Context) error {
 		}
 	}()
 
+	// Has to happen before the terminal is switched into raw mode, refused variables print a warning
+	s.setEnv(session)
+
 	go func() {
 		<-ctx.Done()
 		if s.Logger != nil {
@@ -113,8 +116,11 @@ func (s *SSHServer) SpawnShell(ctx context.Context) error {
 		s.Logger.Log(logger.INFO, "Shell started successfully, waiting for session to complete", "connect", s.SessionID)
 	}
 
+	s.ExitStatus = 0
 	if err := session.Wait(); err != nil {
+		s.ExitStatus = -1
 		if e, ok := err.(*ssh.ExitError); ok {
+			s.ExitStatus = e.ExitStatus()
 			if s.Logger != nil {
 				s.Logger.Log(logger.DEBUG, fmt.Sprintf("Session exited with status: %d", e.ExitStatus()), "connect", s.SessionID)
 			}
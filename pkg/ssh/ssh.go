@@ -2,31 +2,55 @@ package ssh
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/melbahja/goph"
-	cryptSSH "golang.org/x/crypto/ssh"
 	"github.com/mikeunge/sshman/pkg/logger"
+	"github.com/pterm/pterm"
+	cryptSSH "golang.org/x/crypto/ssh"
 )
 
 type SSHServer struct {
 	User             string
 	Host             string
+	Port             uint
 	SecureConnection bool
+	HostKeyCallback  cryptSSH.HostKeyCallback // verifies the host key instead of SecureConnection if set
 	Client           *goph.Client
 	Logger           *logger.Logger
 	SessionID        string
+	ExitStatus       int               // exit status of the last shell session, -1 if the session didn't exit cleanly
+	Env              map[string]string // environment variables sent to every session
+
+	envWarningShown bool
 }
 
 func (s SSHServer) generateSSHClient(auth goph.Auth) (*goph.Client, error) {
-	if s.SecureConnection {
-		client, err := goph.New(s.User, s.Host, auth)
+	port := s.Port
+	if port == 0 {
+		port = 22
+	}
+
+	callback := cryptSSH.InsecureIgnoreHostKey()
+	if s.HostKeyCallback != nil {
+		callback = s.HostKeyCallback
+	} else if s.SecureConnection {
+		knownHosts, err := goph.DefaultKnownHosts()
 		if err != nil {
 			return &goph.Client{}, err
 		}
-		return client, nil
+		callback = knownHosts
 	}
 
-	client, err := goph.NewUnknown(s.User, s.Host, auth)
+	client, err := goph.NewConn(&goph.Config{
+		User:     s.User,
+		Addr:     s.Host,
+		Port:     port,
+		Auth:     auth,
+		Timeout:  goph.DefaultTimeout,
+		Callback: callback,
+	})
 	if err != nil {
 		return &goph.Client{}, err
 	}
@@ -35,11 +59,12 @@ func (s SSHServer) generateSSHClient(auth goph.Auth) (*goph.Client, error) {
 
 // ConnectSSHServerWithPrivateKey()
 //
-// @param privateKeyFilePath Path to the SSH RSA private key
-// @param config             SSH server configuration
+// @param privateKey Plain private key, it is wiped once the handshake is done
 //
 // @return error
 func (s *SSHServer) ConnectSSHServerWithPrivateKey(privateKey []byte) error {
+	defer clear(privateKey)
+
 	if s.Logger != nil {
 		s.Logger.Log(logger.DEBUG, "Starting SSH connection with private key authentication", "connect", s.SessionID)
 	}
@@ -56,7 +81,8 @@ func (s *SSHServer) ConnectSSHServerWithPrivateKey(privateKey []byte) error {
 		s.Logger.Log(logger.DEBUG, "Successfully parsed private key, initiating connection", "connect", s.SessionID)
 	}
 
-	auth := goph.Auth{cryptSSH.PublicKeys(signer)}
+	// Servers requiring a second factor ask for it once the key is accepted
+	auth := goph.Auth{cryptSSH.PublicKeys(signer), s.keyboardInteractive()}
 	client, err := s.generateSSHClient(auth)
 	if err != nil {
 		if s.Logger != nil {
@@ -76,16 +102,18 @@ func (s *SSHServer) ConnectSSHServerWithPrivateKey(privateKey []byte) error {
 
 // ConnectSSHServerWithPassword()
 //
-// @param password  Password for authentication
-// @param config    SSH server configuration
+// @param password  Plain password, it is wiped once the handshake is done
 //
 // @return error
-func (s *SSHServer) ConnectSSHServerWithPassword(password string) error {
+func (s *SSHServer) ConnectSSHServerWithPassword(password []byte) error {
+	defer clear(password)
+
 	if s.Logger != nil {
 		s.Logger.Log(logger.DEBUG, "Starting SSH connection with password authentication", "connect", s.SessionID)
 	}
 
-	auth := goph.Password(password)
+	// The ssh package only takes the password as a string, it is created when the server asks for it and not kept afterwards
+	auth := goph.Auth{cryptSSH.PasswordCallback(func() (string, error) { return string(password), nil })}
 	client, err := s.generateSSHClient(auth)
 	if err != nil {
 		if s.Logger != nil {
@@ -102,6 +130,78 @@ func (s *SSHServer) ConnectSSHServerWithPassword(password string) error {
 	return nil
 }
 
+// ConnectSSHServerWithAgent()
+//
+// @param fingerprint  SHA256 fingerprint of the agent key to use, empty offers every key of the agent
+//
+// @return error
+func (s *SSHServer) ConnectSSHServerWithAgent(fingerprint string) error {
+	if s.Logger != nil {
+		s.Logger.Log(logger.DEBUG, "Starting SSH connection with ssh-agent authentication", "connect", s.SessionID)
+	}
+
+	client, conn, err := dialAgent()
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to connect to the ssh-agent", "connect", s.SessionID, err)
+		}
+		return err
+	}
+	// The agent only signs during the handshake
+	defer conn.Close()
+
+	signers, err := agentSigners(client, fingerprint)
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to get the keys of the ssh-agent", "connect", s.SessionID, err)
+		}
+		return err
+	}
+
+	auth := goph.Auth{cryptSSH.PublicKeys(signers...), s.keyboardInteractive()}
+	sshClient, err := s.generateSSHClient(auth)
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to establish SSH connection with the ssh-agent", "connect", s.SessionID, err)
+		}
+		return err
+	}
+	s.Client = sshClient
+
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, "SSH connection established with ssh-agent authentication", "connect", s.SessionID)
+	}
+
+	return nil
+}
+
+// ConnectSSHServerWithKeyboardInteractive()
+//
+// The prompts of the server are answered on the terminal, nothing is stored.
+//
+// @return error
+func (s *SSHServer) ConnectSSHServerWithKeyboardInteractive() error {
+	if s.Logger != nil {
+		s.Logger.Log(logger.DEBUG, "Starting SSH connection with keyboard-interactive authentication", "connect", s.SessionID)
+	}
+
+	auth := goph.Auth{s.keyboardInteractive()}
+	client, err := s.generateSSHClient(auth)
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Failed to establish SSH connection with keyboard-interactive authentication", "connect", s.SessionID, err)
+		}
+		return err
+	}
+	s.Client = client
+
+	if s.Logger != nil {
+		s.Logger.Log(logger.INFO, "SSH connection established with keyboard-interactive authentication", "connect", s.SessionID)
+	}
+
+	return nil
+}
+
 // ExecuteCommand executes a command on the remote server
 func (s *SSHServer) ExecuteCommand(command string) (string, error) {
 	if s.Client == nil {
@@ -116,7 +216,17 @@ func (s *SSHServer) ExecuteCommand(command string) (string, error) {
 		s.Logger.Log(logger.DEBUG, fmt.Sprintf("Executing command: %s", command), "execute", s.SessionID)
 	}
 
-	output, err := s.Client.Run(command)
+	session, err := s.Client.NewSession()
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.LogError("Cannot open new SSH session", "execute", s.SessionID, err)
+		}
+		return "", err
+	}
+	defer session.Close()
+
+	s.setEnv(session)
+	output, err := session.CombinedOutput(command)
 	if err != nil {
 		if s.Logger != nil {
 			s.Logger.LogError(fmt.Sprintf("Failed to execute command: %s", command), "execute", s.SessionID, err)
@@ -130,3 +240,29 @@ func (s *SSHServer) ExecuteCommand(command string) (string, error) {
 
 	return string(output), nil
 }
+
+// setEnv sends the environment variables to the session.
+// Servers only accept the variables listed in AcceptEnv, refused variables are reported once per server.
+func (s *SSHServer) setEnv(session *cryptSSH.Session) {
+	var refused []string
+
+	var names []string
+	for name := range s.Env {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := session.Setenv(name, s.Env[name]); err != nil {
+			refused = append(refused, name)
+			if s.Logger != nil {
+				s.Logger.LogError(fmt.Sprintf("Server refused environment variable %s", name), "connect", s.SessionID, err)
+			}
+		}
+	}
+
+	if len(refused) > 0 && !s.envWarningShown {
+		s.envWarningShown = true
+		pterm.Warning.Printf("The server refused the environment variable(s) %s, add them to AcceptEnv in the server's sshd_config.\n", strings.Join(refused, ", "))
+	}
+}